@@ -0,0 +1,117 @@
+// Package apierrors writes the JSON error responses shared by every
+// handler of the API.
+package apierrors
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/KyleWS/blog-api/api-server/logging"
+	"github.com/KyleWS/blog-api/api-server/models"
+)
+
+// Codes clients can switch on, the message is meant for humans.
+const (
+	CodeInvalidRequest   = "invalid_request"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeConflict         = "conflict"
+	CodeTooLarge         = "too_large"
+	CodeUnsupportedMedia = "unsupported_media_type"
+	CodeRateLimited      = "rate_limited"
+	CodeInternal         = "internal"
+	CodeBadGateway       = "bad_gateway"
+	CodeUnavailable      = "unavailable"
+)
+
+// Envelope is the body of every error response.
+type Envelope struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	RequestID string      `json:"requestId"`
+	Details   interface{} `json:"details,omitempty"`
+}
+
+// Write sends an error response with the given status, code and
+// message. details is included as is when not nil.
+func Write(w http.ResponseWriter, r *http.Request, status int, code string, message string, details interface{}) {
+	envelope := &Envelope{
+		Code:      code,
+		Message:   message,
+		RequestID: logging.RequestID(r.Context()),
+		Details:   details,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(envelope)
+}
+
+// FromStore sends the error response matching an error returned by one
+// of the stores. message says what was being attempted, the error
+// itself is only shown to clients when it is their fault.
+func FromStore(w http.ResponseWriter, r *http.Request, err error, message string) {
+	var validationErr *models.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		Write(w, r, http.StatusBadRequest, CodeInvalidRequest, validationErr.Error(), validationErr.Fields)
+	case errors.Is(err, models.ErrNotFound):
+		Write(w, r, http.StatusNotFound, CodeNotFound, message+": not found", nil)
+	case errors.Is(err, models.ErrConflict):
+		Write(w, r, http.StatusConflict, CodeConflict, err.Error(), nil)
+	case errors.Is(err, models.ErrUnavailable):
+		logError(w, r, err)
+		Write(w, r, http.StatusServiceUnavailable, CodeUnavailable, message+": database unavailable", nil)
+	default:
+		logError(w, r, err)
+		Write(w, r, http.StatusInternalServerError, CodeInternal, message, nil)
+	}
+}
+
+// logError records the details of a server side failure that are
+// kept from the client.
+func logError(w http.ResponseWriter, r *http.Request, err error) {
+	logging.RequestLogger(w, r).WithField("err", err).Error("error handling request")
+}
+
+// discardWriter keeps the status and headers a handler sets but throws
+// away its body.
+type discardWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (dw *discardWriter) WriteHeader(status int) {
+	dw.status = status
+}
+
+func (dw *discardWriter) Write(body []byte) (int, error) {
+	return len(body), nil
+}
+
+// JSONMux wraps mux so the 404 and 405 responses it generates for
+// requests matching none of its routes use the JSON envelope as well.
+func JSONMux(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler, pattern := mux.Handler(r)
+		if len(pattern) > 0 {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		// let the mux work out the status and Allow header
+		recorder := &discardWriter{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(recorder, r)
+		switch recorder.status {
+		case http.StatusNotFound:
+			Write(w, r, http.StatusNotFound, CodeNotFound, "no such route", nil)
+		case http.StatusMethodNotAllowed:
+			Write(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed,
+				"only accepts "+w.Header().Get("Allow"), nil)
+		default:
+			w.WriteHeader(recorder.status)
+		}
+	})
+}
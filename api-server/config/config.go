@@ -0,0 +1,343 @@
+// Package config loads the server configuration from a YAML file,
+// environment variables and command line flags, in increasing order
+// of precedence.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is every setting the server reads. Each field is named in
+// YAML by its yaml tag, in the environment by its env tag and on the
+// command line by its yaml tag with dashes, so posts_db_name is set by
+// POSTS_DB_NAME or --posts-db-name. Lists are comma separated unless
+// the field has a sep tag. Fields tagged reload are reapplied on
+// SIGHUP, the rest need a restart.
+type Config struct {
+	Addr         string `yaml:"addr" env:"ADDR" usage:"address to listen on, also used as the public host when set"`
+	RedirectHost string `yaml:"redirect_host" env:"GITREDIR" usage:"public host the identity providers redirect back to"`
+	PlainHTTP    bool   `yaml:"plain_http" env:"PLAIN_HTTP" usage:"serve plain HTTP behind a proxy that terminates TLS"`
+	TLSCert      string `yaml:"tls_cert" env:"TLS_CERT" usage:"TLS certificate file"`
+	TLSKey       string `yaml:"tls_key" env:"TLS_SECRET" usage:"TLS private key file"`
+	LogLevel     string `yaml:"log_level" env:"LOG_LEVEL" reload:"true" usage:"debug, info, warn or error"`
+
+	DBAddr          string `yaml:"db_addr" env:"DBADDR" secret:"userinfo" usage:"MongoDB address"`
+	DBName          string `yaml:"posts_db_name" env:"POSTS_DB_NAME" usage:"MongoDB database"`
+	PostsCollection string `yaml:"posts_collection" env:"POSTS_COLLECTION_NAME" usage:"collection holding posts"`
+	UsersCollection string `yaml:"users_collection" env:"USERS_COLLECTION_NAME" usage:"collection holding users allowed to sign in"`
+	RulesCollection string `yaml:"rules_collection" env:"RULES_COLLECTION_NAME" usage:"collection holding organization access rules"`
+	AuditCollection string `yaml:"audit_collection" env:"AUDIT_COLLECTION_NAME" usage:"collection holding the audit log"`
+
+	WebhooksCollection   string        `yaml:"webhooks_collection" env:"WEBHOOKS_COLLECTION_NAME" usage:"collection holding webhooks"`
+	DeliveriesCollection string        `yaml:"webhook_deliveries_collection" env:"WEBHOOK_DELIVERIES_COLLECTION_NAME" usage:"collection holding the webhook delivery queue and log"`
+	WebhookTimeout       time.Duration `yaml:"webhook_timeout" env:"WEBHOOK_TIMEOUT" usage:"time allowed for a webhook to respond"`
+	WebhookMaxAttempts   int           `yaml:"webhook_max_attempts" env:"WEBHOOK_MAX_ATTEMPTS" usage:"attempts at a webhook delivery before giving up"`
+
+	EventsBuffer    int           `yaml:"events_buffer" env:"EVENTS_BUFFER" usage:"post events kept for clients resuming an event stream"`
+	EventsHeartbeat time.Duration `yaml:"events_heartbeat" env:"EVENTS_HEARTBEAT" usage:"how often idle event streams are sent a heartbeat and signed in streams check their session"`
+
+	RequestMaxBytes     int           `yaml:"request_max_bytes" env:"REQUEST_MAX_BYTES" usage:"largest request body accepted, in bytes"`
+	PostMaxTitleLength  int           `yaml:"post_max_title_length" env:"POST_MAX_TITLE_LENGTH" usage:"longest post title, in characters"`
+	PostMaxBodyBytes    int           `yaml:"post_max_body_bytes" env:"POST_MAX_BODY_BYTES" usage:"largest post body, in bytes"`
+	PostMaxTags         int           `yaml:"post_max_tags" env:"POST_MAX_TAGS" usage:"most tags a post may have"`
+	PostMaxTagLength    int           `yaml:"post_max_tag_length" env:"POST_MAX_TAG_LENGTH" usage:"longest tag, in characters"`
+	PostMaxPublishAhead time.Duration `yaml:"post_max_publish_ahead" env:"POST_MAX_PUBLISH_AHEAD" usage:"how far ahead posts may be scheduled to publish"`
+	BulkMaxPosts        int           `yaml:"bulk_max_posts" env:"BULK_MAX_POSTS" usage:"most posts one bulk operation may change"`
+
+	HTMLURLSchemes  []string `yaml:"html_url_schemes" env:"HTML_URL_SCHEMES" usage:"URL schemes links and images in post bodies may use"`
+	HTMLIframeHosts []string `yaml:"html_iframe_hosts" env:"HTML_IFRAME_HOSTS" usage:"hosts post bodies may embed iframes from over https"`
+
+	GraphQLMaxDepth int `yaml:"graphql_max_depth" env:"GRAPHQL_MAX_DEPTH" usage:"deepest nesting allowed in a GraphQL query"`
+	GraphQLMaxCost  int `yaml:"graphql_max_cost" env:"GRAPHQL_MAX_COST" usage:"most fields a GraphQL query may resolve, counting every item a list may return"`
+
+	Whitelist []string `yaml:"whitelist" env:"BLOGAPI_WHITELIST" usage:"users seeded into an empty user store, the first as admin and the rest as writers, as provider:login or GitHub logins"`
+
+	GithubClientID     string   `yaml:"github_client_id" env:"CLIENT_ID" usage:"GitHub OAuth client ID"`
+	GithubClientSecret string   `yaml:"github_client_secret" env:"CLIENT_SECRET" secret:"true" usage:"GitHub OAuth client secret"`
+	OIDCIssuer         string   `yaml:"oidc_issuer" env:"OIDC_ISSUER" usage:"OpenID Connect issuer URL"`
+	OIDCClientID       string   `yaml:"oidc_client_id" env:"OIDC_CLIENT_ID" usage:"OpenID Connect client ID"`
+	OIDCClientSecret   string   `yaml:"oidc_client_secret" env:"OIDC_CLIENT_SECRET" secret:"true" usage:"OpenID Connect client secret"`
+	OIDCScopes         []string `yaml:"oidc_scopes" env:"OIDC_SCOPES" usage:"OpenID Connect scopes"`
+	OIDCUsernameClaim  string   `yaml:"oidc_username_claim" env:"OIDC_USERNAME_CLAIM" usage:"ID token claim holding the login"`
+
+	SessionIdleTimeout     time.Duration `yaml:"session_idle_timeout" env:"SESSION_IDLE_TIMEOUT" usage:"sessions unused this long expire"`
+	SessionAbsoluteTimeout time.Duration `yaml:"session_absolute_timeout" env:"SESSION_ABSOLUTE_TIMEOUT" usage:"sessions expire this long after sign in"`
+	FrontendOrigins        []string      `yaml:"frontend_origins" env:"FRONTEND_ORIGINS" usage:"origins users may be sent back to after sign in"`
+	SessionDelivery        string        `yaml:"session_delivery" env:"SESSION_DELIVERY" usage:"fragment or cookie"`
+	SessionCookieSameSite  string        `yaml:"session_cookie_samesite" env:"SESSION_COOKIE_SAMESITE" usage:"lax, strict or none"`
+	DisableAuthQuery       bool          `yaml:"disable_auth_query" env:"DISABLE_AUTH_QUERY" usage:"refuse sessions sent in the auth query parameter"`
+
+	CORSAllowedOrigins   []string      `yaml:"cors_allowed_origins" env:"CORS_ALLOWED_ORIGINS" usage:"origins allowed to call the API, the frontend origins when empty, * allows any but not with cookie sessions"`
+	CORSAllowCredentials bool          `yaml:"cors_allow_credentials" env:"CORS_ALLOW_CREDENTIALS" usage:"let browsers send cookies cross origin"`
+	CORSMaxAge           time.Duration `yaml:"cors_max_age" env:"CORS_MAX_AGE" usage:"how long browsers may cache preflight responses"`
+
+	RateLimitAnonymous     string   `yaml:"rate_limit_anonymous" env:"RATE_LIMIT_ANONYMOUS" usage:"requests/duration allowed per signed out client"`
+	RateLimitAuthenticated string   `yaml:"rate_limit_authenticated" env:"RATE_LIMIT_AUTHENTICATED" usage:"requests/duration allowed per signed in user"`
+	RateLimitRoutes        []string `yaml:"rate_limit_routes" env:"RATE_LIMIT_ROUTES" sep:";" usage:"per route limits such as \"GET /all=20/1m\""`
+	TrustedProxies         []string `yaml:"trusted_proxies" env:"TRUSTED_PROXIES" usage:"proxies whose X-Forwarded-For and request IDs we believe"`
+
+	MetricsAddr   string `yaml:"metrics_addr" env:"METRICS_ADDR" usage:"separate address to serve /metrics on"`
+	MetricsToken  string `yaml:"metrics_token" env:"METRICS_TOKEN" secret:"true" usage:"bearer token scrapers must send"`
+	TraceExporter string `yaml:"trace_exporter" env:"TRACE_EXPORTER" usage:"stdout or otlp, empty leaves tracing off"`
+
+	AccessLogFormat          string  `yaml:"access_log_format" env:"ACCESS_LOG_FORMAT" usage:"json, combined or off"`
+	AccessLogSampleSuccesses float64 `yaml:"access_log_sample_successes" env:"ACCESS_LOG_SAMPLE_SUCCESSES" usage:"fraction of successful requests logged, errors are always logged"`
+
+	HTTPReadHeaderTimeout time.Duration `yaml:"http_read_header_timeout" env:"HTTP_READ_HEADER_TIMEOUT" usage:"time allowed to read request headers"`
+	HTTPReadTimeout       time.Duration `yaml:"http_read_timeout" env:"HTTP_READ_TIMEOUT" usage:"time allowed to read a request"`
+	HTTPWriteTimeout      time.Duration `yaml:"http_write_timeout" env:"HTTP_WRITE_TIMEOUT" usage:"time allowed to write a response"`
+	HTTPIdleTimeout       time.Duration `yaml:"http_idle_timeout" env:"HTTP_IDLE_TIMEOUT" usage:"time idle keep-alive connections are kept"`
+	ShutdownDrainDelay    time.Duration `yaml:"shutdown_drain_delay" env:"SHUTDOWN_DRAIN_DELAY" usage:"time to report draining before shutting down"`
+	ShutdownTimeout       time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" usage:"time allowed for in flight requests on shutdown"`
+}
+
+// Default returns the configuration used for anything left unset.
+func Default() *Config {
+	return &Config{
+		LogLevel:               "warn",
+		UsersCollection:        "users",
+		RulesCollection:        "access_rules",
+		AuditCollection:        "audit_log",
+		WebhooksCollection:     "webhooks",
+		DeliveriesCollection:   "webhook_deliveries",
+		WebhookTimeout:         10 * time.Second,
+		WebhookMaxAttempts:     8,
+		EventsBuffer:           256,
+		EventsHeartbeat:        15 * time.Second,
+		RequestMaxBytes:        2 << 20,
+		PostMaxTitleLength:     200,
+		PostMaxBodyBytes:       1 << 20,
+		PostMaxTags:            20,
+		PostMaxTagLength:       40,
+		PostMaxPublishAhead:    365 * 24 * time.Hour,
+		BulkMaxPosts:           500,
+		HTMLURLSchemes:         []string{"http", "https", "mailto"},
+		HTMLIframeHosts:        []string{"www.youtube-nocookie.com", "player.vimeo.com"},
+		GraphQLMaxDepth:        8,
+		GraphQLMaxCost:         1000,
+		SessionIdleTimeout:     120 * time.Minute,
+		SessionAbsoluteTimeout: 12 * time.Hour,
+		SessionDelivery:        "fragment",
+		SessionCookieSameSite:  "lax",
+		CORSMaxAge:             10 * time.Minute,
+		RateLimitAnonymous:     "60/1m",
+		RateLimitAuthenticated: "600/1m",
+		// listing scans the whole collection and signing in calls out
+		// to the provider, keep both on a tighter leash
+		RateLimitRoutes:          []string{"GET /all=20/1m", "GET /v1/posts=20/1m", "/oauth/signin=10/1m"},
+		AccessLogFormat:          "json",
+		AccessLogSampleSuccesses: 1,
+		HTTPReadHeaderTimeout:    10 * time.Second,
+		HTTPReadTimeout:          30 * time.Second,
+		HTTPWriteTimeout:         60 * time.Second,
+		HTTPIdleTimeout:          2 * time.Minute,
+		ShutdownDrainDelay:       5 * time.Second,
+		ShutdownTimeout:          30 * time.Second,
+	}
+}
+
+// Options are the command line flags that are not settings.
+type Options struct {
+	// File is the YAML file to read, from --config or CONFIG_FILE
+	File string
+	// PrintConfig asks for the configuration to be printed
+	PrintConfig bool
+}
+
+// Load builds the configuration from the defaults, the YAML file, the
+// environment and args, the command line without the program name.
+// It does not validate the result.
+func Load(args []string) (*Config, *Options, error) {
+	cfg := Default()
+	opts := &Options{File: os.Getenv("CONFIG_FILE")}
+
+	flags := flag.NewFlagSet("api-server", flag.ContinueOnError)
+	flags.StringVar(&opts.File, "config", opts.File, "YAML configuration file")
+	flags.BoolVar(&opts.PrintConfig, "print-config", false, "print the configuration with secrets redacted and exit")
+	// flag values are only applied once the file and environment
+	// have been, so they take precedence
+	setByFlag := map[string]string{}
+	for _, field := range fields(cfg) {
+		field := field
+		record := func(value string) error {
+			setByFlag[field.flagName] = value
+			return nil
+		}
+		// bool flags may be given bare, as -plain-http
+		if _, isBool := field.value.Interface().(bool); isBool {
+			flags.BoolFunc(field.flagName, field.usage, record)
+		} else {
+			flags.Func(field.flagName, field.usage, record)
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if len(opts.File) > 0 {
+		raw, err := os.ReadFile(opts.File)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading config file: %v", err)
+		}
+		decoder := yaml.NewDecoder(strings.NewReader(string(raw)))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil && err != io.EOF {
+			return nil, nil, fmt.Errorf("error parsing config file %s: %v", opts.File, err)
+		}
+	}
+	for _, field := range fields(cfg) {
+		if value, ok := os.LookupEnv(field.env); ok && len(value) > 0 {
+			if err := field.set(value); err != nil {
+				return nil, nil, fmt.Errorf("error in environment variable %s: %v", field.env, err)
+			}
+		}
+	}
+	for _, field := range fields(cfg) {
+		if value, ok := setByFlag[field.flagName]; ok {
+			if err := field.set(value); err != nil {
+				return nil, nil, fmt.Errorf("error in flag --%s: %v", field.flagName, err)
+			}
+		}
+	}
+	return cfg, opts, nil
+}
+
+// Print writes cfg as YAML with secrets redacted.
+func (cfg *Config) Print(w io.Writer) error {
+	redacted := *cfg
+	for _, field := range fields(&redacted) {
+		if field.value.Kind() != reflect.String || field.value.Len() == 0 {
+			continue
+		}
+		switch field.secret {
+		case "true":
+			field.value.SetString("REDACTED")
+		case "userinfo":
+			// keep the host, hide any credentials before it
+			value := field.value.String()
+			if at := strings.LastIndex(value, "@"); at >= 0 {
+				scheme := ""
+				if i := strings.Index(value, "://"); i >= 0 && i < at {
+					scheme = value[:i+3]
+				}
+				field.value.SetString(scheme + "REDACTED" + value[at:])
+			}
+		}
+	}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&redacted); err != nil {
+		return fmt.Errorf("error printing config: %v", err)
+	}
+	return encoder.Close()
+}
+
+// Reload copies the fields that may change while running from next
+// and returns the names of any others that differ, which only take
+// effect after a restart.
+func (cfg *Config) Reload(next *Config) []string {
+	var restart []string
+	nextFields := fields(next)
+	for i, field := range fields(cfg) {
+		if reflect.DeepEqual(field.value.Interface(), nextFields[i].value.Interface()) {
+			continue
+		}
+		if field.reload {
+			field.value.Set(nextFields[i].value)
+		} else {
+			restart = append(restart, field.yamlName)
+		}
+	}
+	sort.Strings(restart)
+	return restart
+}
+
+// field is one setting of a Config.
+type field struct {
+	value    reflect.Value
+	yamlName string
+	flagName string
+	env      string
+	sep      string
+	secret   string
+	reload   bool
+	usage    string
+}
+
+// fields lists the settings of cfg, in declaration order.
+func fields(cfg *Config) []*field {
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	result := make([]*field, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag
+		f := &field{
+			value:    v.Field(i),
+			yamlName: tag.Get("yaml"),
+			flagName: strings.ReplaceAll(tag.Get("yaml"), "_", "-"),
+			env:      tag.Get("env"),
+			sep:      tag.Get("sep"),
+			secret:   tag.Get("secret"),
+			reload:   tag.Get("reload") == "true",
+			usage:    fmt.Sprintf("%s (env %s)", tag.Get("usage"), tag.Get("env")),
+		}
+		if len(f.sep) == 0 {
+			f.sep = ","
+		}
+		result = append(result, f)
+	}
+	return result
+}
+
+// set parses value into the field.
+func (f *field) set(value string) error {
+	switch f.value.Interface().(type) {
+	case string:
+		f.value.SetString(value)
+	case bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", value)
+		}
+		f.value.SetBool(parsed)
+	case int:
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", value)
+		}
+		f.value.SetInt(int64(parsed))
+	case float64:
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		f.value.SetFloat(parsed)
+	case time.Duration:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 90s or 2h", value)
+		}
+		f.value.SetInt(int64(parsed))
+	case []string:
+		var list []string
+		for _, entry := range strings.Split(value, f.sep) {
+			if entry = strings.TrimSpace(entry); len(entry) > 0 {
+				list = append(list, entry)
+			}
+		}
+		f.value.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported setting type %s", f.value.Type())
+	}
+	return nil
+}
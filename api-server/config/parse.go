@@ -0,0 +1,62 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit allows Requests requests per Per, in bursts of up to Requests.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// ParseLimit parses a limit written as "requests/duration", such as
+// "60/1m".
+func ParseLimit(value string) (Limit, error) {
+	slash := strings.Index(value, "/")
+	if slash < 0 {
+		return Limit{}, fmt.Errorf("error limit %q is not of the form requests/duration", value)
+	}
+	requests, err := strconv.Atoi(strings.TrimSpace(value[:slash]))
+	if err != nil || requests <= 0 {
+		return Limit{}, fmt.Errorf("error limit %q must allow a positive number of requests", value)
+	}
+	per, err := time.ParseDuration(strings.TrimSpace(value[slash+1:]))
+	if err != nil || per <= 0 {
+		return Limit{}, fmt.Errorf("error limit %q must have a positive duration", value)
+	}
+	return Limit{Requests: requests, Per: per}, nil
+}
+
+// ParseTrustedProxies parses a list of IP addresses and CIDR networks
+// into networks, an address being a network of its own.
+func ParseTrustedProxies(entries []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("error %q is not an IP address", entry)
+			}
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+			entry = fmt.Sprintf("%s/%d", entry, bits)
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing trusted proxy: %v", err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
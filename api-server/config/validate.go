@@ -0,0 +1,208 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ValidationError lists every problem found with a configuration.
+type ValidationError struct {
+	Problems []string
+}
+
+func (ve *ValidationError) Error() string {
+	return "error invalid configuration:\n  " + strings.Join(ve.Problems, "\n  ")
+}
+
+func (ve *ValidationError) add(format string, args ...interface{}) {
+	ve.Problems = append(ve.Problems, fmt.Sprintf(format, args...))
+}
+
+// Validate checks cfg can be used to start the server, returning a
+// *ValidationError listing everything wrong with it.
+func (cfg *Config) Validate() error {
+	problems := &ValidationError{}
+	if len(cfg.DBAddr) == 0 {
+		problems.add("db_addr (DBADDR) is required")
+	}
+	if len(cfg.DBName) == 0 {
+		problems.add("posts_db_name (POSTS_DB_NAME) is required")
+	}
+	if len(cfg.PostsCollection) == 0 {
+		problems.add("posts_collection (POSTS_COLLECTION_NAME) is required")
+	}
+	if len(cfg.Addr) == 0 && len(cfg.RedirectHost) == 0 {
+		problems.add("addr (ADDR) or redirect_host (GITREDIR) is required to build the sign in redirect URL")
+	}
+	if !cfg.PlainHTTP && (len(cfg.TLSCert) == 0 || len(cfg.TLSKey) == 0) {
+		problems.add("tls_cert (TLS_CERT) and tls_key (TLS_SECRET) are required unless plain_http is true")
+	}
+	if _, err := cfg.Level(); err != nil {
+		problems.add("log_level: %v", err)
+	}
+
+	if len(cfg.GithubClientID) == 0 && len(cfg.OIDCIssuer) == 0 {
+		problems.add("no identity provider configured, set github_client_id (CLIENT_ID) and/or oidc_issuer (OIDC_ISSUER)")
+	}
+	if len(cfg.GithubClientID) > 0 && len(cfg.GithubClientSecret) == 0 {
+		problems.add("github_client_secret (CLIENT_SECRET) is required with github_client_id")
+	}
+	if len(cfg.OIDCIssuer) > 0 && len(cfg.OIDCClientID) == 0 {
+		problems.add("oidc_client_id (OIDC_CLIENT_ID) is required with oidc_issuer")
+	}
+	for _, entry := range cfg.Whitelist {
+		if strings.HasPrefix(entry, ":") || strings.HasSuffix(entry, ":") {
+			problems.add("whitelist: %q must be provider:login or a GitHub login", entry)
+		}
+	}
+
+	positive := []struct {
+		name     string
+		duration time.Duration
+	}{
+		{"session_idle_timeout", cfg.SessionIdleTimeout},
+		{"session_absolute_timeout", cfg.SessionAbsoluteTimeout},
+		{"cors_max_age", cfg.CORSMaxAge},
+		{"http_read_header_timeout", cfg.HTTPReadHeaderTimeout},
+		{"http_read_timeout", cfg.HTTPReadTimeout},
+		{"http_write_timeout", cfg.HTTPWriteTimeout},
+		{"http_idle_timeout", cfg.HTTPIdleTimeout},
+		{"shutdown_timeout", cfg.ShutdownTimeout},
+		{"webhook_timeout", cfg.WebhookTimeout},
+		{"events_heartbeat", cfg.EventsHeartbeat},
+		{"post_max_publish_ahead", cfg.PostMaxPublishAhead},
+	}
+	for _, setting := range positive {
+		if setting.duration <= 0 {
+			problems.add("%s must be positive", setting.name)
+		}
+	}
+	if cfg.WebhookMaxAttempts <= 0 {
+		problems.add("webhook_max_attempts must be positive")
+	}
+	if cfg.EventsBuffer <= 0 {
+		problems.add("events_buffer must be positive")
+	}
+	for _, setting := range []struct {
+		name  string
+		limit int
+	}{
+		{"request_max_bytes", cfg.RequestMaxBytes},
+		{"post_max_title_length", cfg.PostMaxTitleLength},
+		{"post_max_body_bytes", cfg.PostMaxBodyBytes},
+		{"post_max_tags", cfg.PostMaxTags},
+		{"post_max_tag_length", cfg.PostMaxTagLength},
+		{"bulk_max_posts", cfg.BulkMaxPosts},
+	} {
+		if setting.limit <= 0 {
+			problems.add("%s must be positive", setting.name)
+		}
+	}
+	if cfg.PostMaxBodyBytes >= cfg.RequestMaxBytes {
+		problems.add("request_max_bytes must be more than post_max_body_bytes")
+	}
+	for _, scheme := range cfg.HTMLURLSchemes {
+		if strings.EqualFold(scheme, "javascript") || strings.EqualFold(scheme, "vbscript") || strings.EqualFold(scheme, "data") {
+			problems.add("html_url_schemes: %q runs or embeds content and cannot be allowed", scheme)
+		}
+	}
+	for _, host := range cfg.HTMLIframeHosts {
+		if len(host) == 0 || strings.ContainsAny(host, "/:*") {
+			problems.add("html_iframe_hosts: %q must be a host name such as player.vimeo.com", host)
+		}
+	}
+	if cfg.GraphQLMaxDepth <= 0 {
+		problems.add("graphql_max_depth must be positive")
+	}
+	if cfg.GraphQLMaxCost <= 0 {
+		problems.add("graphql_max_cost must be positive")
+	}
+	if cfg.ShutdownDrainDelay < 0 {
+		problems.add("shutdown_drain_delay cannot be negative")
+	}
+
+	if cfg.SessionDelivery != "fragment" && cfg.SessionDelivery != "cookie" {
+		problems.add("session_delivery must be fragment or cookie, not %q", cfg.SessionDelivery)
+	}
+	switch strings.ToLower(cfg.SessionCookieSameSite) {
+	case "lax", "strict", "none":
+	default:
+		problems.add("session_cookie_samesite must be lax, strict or none, not %q", cfg.SessionCookieSameSite)
+	}
+	switch cfg.TraceExporter {
+	case "", "stdout", "otlp":
+	default:
+		problems.add("trace_exporter must be stdout, otlp or empty, not %q", cfg.TraceExporter)
+	}
+	switch cfg.AccessLogFormat {
+	case "json", "combined", "off":
+	default:
+		problems.add("access_log_format must be json, combined or off, not %q", cfg.AccessLogFormat)
+	}
+	if cfg.AccessLogSampleSuccesses < 0 || cfg.AccessLogSampleSuccesses > 1 {
+		problems.add("access_log_sample_successes must be between 0 and 1")
+	}
+
+	for _, origin := range cfg.CORSOrigins() {
+		if origin != "*" {
+			continue
+		}
+		if cfg.CORSAllowCredentials {
+			problems.add("cors_allow_credentials: credentials cannot be allowed for any origin, list the allowed origins instead")
+		}
+		if cfg.SessionDelivery == "cookie" {
+			problems.add("cors_allowed_origins: * cannot be used with cookie sessions, list the allowed origins instead")
+		}
+	}
+	if _, err := ParseLimit(cfg.RateLimitAnonymous); err != nil {
+		problems.add("rate_limit_anonymous: %v", err)
+	}
+	if _, err := ParseLimit(cfg.RateLimitAuthenticated); err != nil {
+		problems.add("rate_limit_authenticated: %v", err)
+	}
+	if _, err := cfg.RouteLimits(); err != nil {
+		problems.add("rate_limit_routes: %v", err)
+	}
+	if _, err := ParseTrustedProxies(cfg.TrustedProxies); err != nil {
+		problems.add("trusted_proxies: %v", err)
+	}
+
+	if len(problems.Problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// Level parses LogLevel.
+func (cfg *Config) Level() (logrus.Level, error) {
+	return logrus.ParseLevel(cfg.LogLevel)
+}
+
+// CORSOrigins returns the origins allowed to call the API, the
+// frontend origins unless others are listed.
+func (cfg *Config) CORSOrigins() []string {
+	if len(cfg.CORSAllowedOrigins) == 0 {
+		return cfg.FrontendOrigins
+	}
+	return cfg.CORSAllowedOrigins
+}
+
+// RouteLimits parses RateLimitRoutes into limits by mux pattern.
+func (cfg *Config) RouteLimits() (map[string]Limit, error) {
+	limits := make(map[string]Limit, len(cfg.RateLimitRoutes))
+	for _, entry := range cfg.RateLimitRoutes {
+		pattern, value, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("error %q must look like \"GET /all=20/1m\"", entry)
+		}
+		limit, err := ParseLimit(value)
+		if err != nil {
+			return nil, err
+		}
+		limits[strings.TrimSpace(pattern)] = limit
+	}
+	return limits, nil
+}
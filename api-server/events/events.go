@@ -0,0 +1,183 @@
+// Package events broadcasts changes to posts to the clients streaming
+// them, keeping the most recent ones so clients that reconnect can
+// catch up.
+package events
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KyleWS/blog-api/api-server/models"
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind
+// by before it is dropped
+const subscriberBuffer = 64
+
+// Event is one change to a post.
+type Event struct {
+	// ID orders events, clients send the last one they saw in the
+	// Last-Event-ID header when they reconnect
+	ID   string
+	Type string
+	// Data is the JSON sent to clients
+	Data []byte
+	// Public events are about posts published before or after the
+	// change, anyone may see them
+	Public bool
+}
+
+// eventData is the JSON sent with every event, the post without its
+// body.
+type eventData struct {
+	Type string            `json:"type"`
+	Post *models.PostShort `json:"post"`
+}
+
+// Subscription receives events as they are published. C is closed when
+// the subscriber fell too far behind or the broker closed.
+type Subscription struct {
+	C      <-chan *Event
+	c      chan *Event
+	public bool
+}
+
+// Broker hands published events to every subscription and keeps the
+// last few so reconnecting clients miss nothing.
+type Broker struct {
+	lock sync.Mutex
+	// epoch tells IDs handed out before a restart from ours
+	epoch       string
+	next        uint64
+	ring        []*Event
+	size        int
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+// NewBroker returns a broker remembering the last size events.
+func NewBroker(size int) *Broker {
+	return &Broker{
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		next:        1,
+		ring:        make([]*Event, 0, size),
+		size:        size,
+		subscribers: map[*Subscription]struct{}{},
+	}
+}
+
+// Publish broadcasts an event of eventType about post, which was before
+// until the change, nil for new posts. Only events about posts that
+// were or are published reach public subscriptions, so they also hear
+// of posts being unpublished.
+func (b *Broker) Publish(eventType string, before *models.TextPost, post *models.TextPost) {
+	data, err := json.Marshal(&eventData{
+		Type: eventType,
+		Post: post.Short(),
+	})
+	if err != nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.closed {
+		return
+	}
+	event := &Event{
+		ID:     b.epoch + "-" + strconv.FormatUint(b.next, 10),
+		Type:   eventType,
+		Data:   data,
+		Public: !post.DraftMode || (before != nil && !before.DraftMode),
+	}
+	b.next++
+	if len(b.ring) == b.size {
+		copy(b.ring, b.ring[1:])
+		b.ring = b.ring[:b.size-1]
+	}
+	b.ring = append(b.ring, event)
+
+	for sub := range b.subscribers {
+		if sub.public && !event.Public {
+			continue
+		}
+		select {
+		case sub.c <- event:
+		default:
+			// too slow, it can reconnect and catch up from the ring
+			delete(b.subscribers, sub)
+			close(sub.c)
+		}
+	}
+}
+
+// Subscribe returns a subscription to events published from now on,
+// and the events published after lastID that are still remembered. ok
+// is false when lastID is set but events since it may have been
+// forgotten, so the client should refetch everything.
+func (b *Broker) Subscribe(lastID string, public bool) (sub *Subscription, missed []*Event, ok bool) {
+	c := make(chan *Event, subscriberBuffer)
+	sub = &Subscription{C: c, c: c, public: public}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.closed {
+		close(c)
+		return sub, nil, true
+	}
+	b.subscribers[sub] = struct{}{}
+	if len(lastID) == 0 {
+		return sub, nil, true
+	}
+
+	seen, known := b.sequence(lastID)
+	if !known {
+		return sub, nil, false
+	}
+	first := b.next - uint64(len(b.ring))
+	// seen+1 is the first event the client has not had
+	ok = seen+1 >= first
+	for i, event := range b.ring {
+		if first+uint64(i) > seen && (event.Public || !public) {
+			missed = append(missed, event)
+		}
+	}
+	return sub, missed, ok
+}
+
+// Unsubscribe stops sub receiving events.
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if _, found := b.subscribers[sub]; found {
+		delete(b.subscribers, sub)
+		close(sub.c)
+	}
+}
+
+// Close ends every subscription, so streams finish when the server
+// shuts down.
+func (b *Broker) Close() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.closed = true
+	for sub := range b.subscribers {
+		delete(b.subscribers, sub)
+		close(sub.c)
+	}
+}
+
+// sequence returns the sequence number of id, known is false for IDs
+// that are malformed, from before a restart or not yet handed out.
+func (b *Broker) sequence(id string) (seq uint64, known bool) {
+	epoch, number, found := strings.Cut(id, "-")
+	if !found || epoch != b.epoch {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(number, 10, 64)
+	if err != nil || seq >= b.next {
+		return 0, false
+	}
+	return seq, true
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/KyleWS/blog-api/api-server/logging"
+	"github.com/KyleWS/blog-api/api-server/sessions"
+	logrus "github.com/sirupsen/logrus"
+)
+
+const (
+	// AccessLogJSON writes one JSON line per request
+	AccessLogJSON = "json"
+	// AccessLogCombined writes the Apache combined log format
+	AccessLogCombined = "combined"
+	// AccessLogOff writes nothing
+	AccessLogOff = "off"
+)
+
+// AccessLog writes a line for every request once it has been answered,
+// with the status, size and time taken.
+type AccessLog struct {
+	Handler http.Handler
+	// Routes is consulted to find the route a request is for
+	Routes *http.ServeMux
+	Auth   *sessions.AuthContext
+	// Format is AccessLogJSON, AccessLogCombined or AccessLogOff
+	Format string
+	// SampleSuccesses is the fraction of requests answered without an
+	// error that are logged, errors are always logged
+	SampleSuccesses float64
+	// TrustedProxies are the proxies whose X-Forwarded-For header
+	// we believe
+	TrustedProxies Proxies
+
+	// lines are written separately from the application log so they
+	// do not depend on its level
+	logger *logrus.Logger
+	lock   sync.Mutex
+	out    io.Writer
+}
+
+// NewAccessLog returns handler logging every request to out in format,
+// routes is the mux behind handler.
+func NewAccessLog(handler http.Handler, routes *http.ServeMux, auth *sessions.AuthContext, format string, out io.Writer) *AccessLog {
+	logger := logrus.New()
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.SetOutput(out)
+	return &AccessLog{
+		Handler:         handler,
+		Routes:          routes,
+		Auth:            auth,
+		Format:          format,
+		SampleSuccesses: 1,
+		logger:          logger,
+		out:             out,
+	}
+}
+
+func (al *AccessLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if al.Format == AccessLogOff {
+		al.Handler.ServeHTTP(w, r)
+		return
+	}
+	// looked up first, signing out ends the session
+	principal, _ := al.Auth.SessionPrincipal(r)
+	start := time.Now()
+	recorder := logging.NewResponseRecorder(w)
+	al.Handler.ServeHTTP(recorder, r)
+	duration := time.Since(start)
+
+	if recorder.Status() < http.StatusBadRequest && rand.Float64() >= al.SampleSuccesses {
+		return
+	}
+	if al.Format == AccessLogCombined {
+		al.writeCombined(r, recorder, principal, start)
+		return
+	}
+	fields := logrus.Fields{
+		"method":     r.Method,
+		"uri":        r.URL.RequestURI(),
+		"status":     recorder.Status(),
+		"bytes":      recorder.Bytes(),
+		"durationMs": float64(duration.Microseconds()) / 1000,
+		"remote":     al.TrustedProxies.ClientIP(r),
+		"agent":      r.UserAgent(),
+	}
+	if _, pattern := al.Routes.Handler(r); len(pattern) > 0 {
+		fields["route"] = pattern
+	}
+	if len(principal) > 0 {
+		fields["principal"] = principal
+	}
+	if id := logging.RequestID(r.Context()); len(id) > 0 {
+		fields["RequestID"] = id
+	}
+	al.logger.WithFields(fields).Info("request served")
+}
+
+// writeCombined writes the request in the Apache combined log format.
+func (al *AccessLog) writeCombined(r *http.Request, recorder *logging.ResponseRecorder, principal string, start time.Time) {
+	line := fmt.Sprintf("%s - %s [%s] %q %d %d %q %q\n",
+		al.TrustedProxies.ClientIP(r),
+		combinedField(principal),
+		start.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method+" "+r.URL.RequestURI()+" "+r.Proto,
+		recorder.Status(),
+		recorder.Bytes(),
+		combinedField(r.Referer()),
+		combinedField(r.UserAgent()),
+	)
+	al.lock.Lock()
+	defer al.lock.Unlock()
+	io.WriteString(al.out, line)
+}
+
+// combinedField returns value, or "-" when it is empty, as the combined
+// format expects.
+func combinedField(value string) string {
+	if len(value) == 0 {
+		return "-"
+	}
+	return value
+}
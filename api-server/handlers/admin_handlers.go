@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/KyleWS/blog-api/api-server/apierrors"
+	"github.com/KyleWS/blog-api/api-server/logging"
+	"github.com/KyleWS/blog-api/api-server/models"
+	"github.com/KyleWS/blog-api/api-server/sessions"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// checkAdmin returns the session of the signed in admin making the
+// request, writing an error response if there is none.
+func (ctx *ReqCtx) checkAdmin(w http.ResponseWriter, r *http.Request) (*sessions.SessionState, bool) {
+	state, err := ctx.Auth.CheckAuthToken(w, r)
+	if err != nil {
+		apierrors.Write(w, r, http.StatusUnauthorized, apierrors.CodeUnauthorized, fmt.Sprintf("error access token required: %v", err), nil)
+		return nil, false
+	}
+	if state.Role != models.RoleAdmin {
+		apierrors.Write(w, r, http.StatusForbidden, apierrors.CodeForbidden, "error admin role required", nil)
+		return nil, false
+	}
+	return state, true
+}
+
+// revokeSessions ends the sessions of the user at provider/login,
+// recording in the audit log why admin did so, and returns how many
+// were ended.
+func (ctx *ReqCtx) revokeSessions(r *http.Request, admin *sessions.SessionState, provider string, login string, reason string) int {
+	revoked := ctx.SessionStore.RevokeUser(provider, login)
+	if revoked > 0 {
+		event := models.NewAuditEvent(r.Context(), admin.Principal(), models.AuditSessionRevoke)
+		event.Subject = provider + ":" + login
+		event.Details = map[string]interface{}{
+			"sessions": revoked,
+			"reason":   reason,
+		}
+		ctx.audit(r, event)
+	}
+	return revoked
+}
+
+// ListUsersHandler lists the users allowed to sign in.
+func (ctx *ReqCtx) ListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := ctx.checkAdmin(w, r); !ok {
+		return
+	}
+	users, err := ctx.UserStore.AllUsers()
+	if err != nil {
+		apierrors.FromStore(w, r, err, "error fetching users")
+		return
+	}
+	json.NewEncoder(w).Encode(users)
+}
+
+// CreateUserHandler allows a new user to sign in.
+func (ctx *ReqCtx) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := ctx.checkAdmin(w, r)
+	if !ok {
+		return
+	}
+	decodedNewUser := &models.NewUser{}
+	if !ctx.decodeJSON(w, r, decodedNewUser) {
+		return
+	}
+	newUser, err := decodedNewUser.ToUser(admin.Principal())
+	if err != nil {
+		apierrors.FromStore(w, r, err, "error invalid request")
+		return
+	}
+	if err := ctx.UserStore.InsertUser(newUser); err != nil {
+		apierrors.FromStore(w, r, err, "error inserting new user into store")
+		return
+	}
+	logging.RequestLogger(w, r).WithFields(logrus.Fields{
+		"admin": admin.Principal(),
+		"user":  newUser,
+	}).Warn("handling create user")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newUser)
+}
+
+// UpdateUserHandler changes the role of the user at
+// {provider}/{login}. Sessions the user already holds are revoked so
+// the change takes effect immediately. The last admin cannot be
+// demoted.
+func (ctx *ReqCtx) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := ctx.checkAdmin(w, r)
+	if !ok {
+		return
+	}
+	provider, login := r.PathValue("provider"), r.PathValue("login")
+	updates := &models.UserUpdates{}
+	if !ctx.decodeJSON(w, r, updates) {
+		return
+	}
+	updatedUser, err := ctx.UserStore.UpdateUser(provider, login, updates)
+	if err != nil {
+		apierrors.FromStore(w, r, err, "error updating user")
+		return
+	}
+	revoked := ctx.revokeSessions(r, admin, provider, login, "role changed")
+	logging.RequestLogger(w, r).WithFields(logrus.Fields{
+		"admin":    admin.Principal(),
+		"user":     updatedUser,
+		"sessions": revoked,
+	}).Warn("handling update user")
+	json.NewEncoder(w).Encode(updatedUser)
+}
+
+// DeleteUserHandler stops the user at {provider}/{login} from signing
+// in and revokes the sessions they hold. The last admin cannot be
+// deleted.
+func (ctx *ReqCtx) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := ctx.checkAdmin(w, r)
+	if !ok {
+		return
+	}
+	provider, login := r.PathValue("provider"), r.PathValue("login")
+	if err := ctx.UserStore.DeleteUser(provider, login); err != nil {
+		apierrors.FromStore(w, r, err, "error deleting user")
+		return
+	}
+	revoked := ctx.revokeSessions(r, admin, provider, login, "user deleted")
+	logging.RequestLogger(w, r).WithFields(logrus.Fields{
+		"admin":    admin.Principal(),
+		"provider": provider,
+		"login":    login,
+		"sessions": revoked,
+	}).Warn("handling delete user")
+}
+
+// ListRulesHandler lists the organization rules that admit GitHub
+// users by membership.
+func (ctx *ReqCtx) ListRulesHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := ctx.checkAdmin(w, r); !ok {
+		return
+	}
+	rules, err := ctx.UserStore.AllRules()
+	if err != nil {
+		apierrors.FromStore(w, r, err, "error fetching access rules")
+		return
+	}
+	json.NewEncoder(w).Encode(rules)
+}
+
+// CreateRuleHandler adds an organization rule.
+func (ctx *ReqCtx) CreateRuleHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := ctx.checkAdmin(w, r)
+	if !ok {
+		return
+	}
+	decodedNewRule := &models.NewAccessRule{}
+	if !ctx.decodeJSON(w, r, decodedNewRule) {
+		return
+	}
+	newRule, err := decodedNewRule.ToAccessRule(admin.Principal())
+	if err != nil {
+		apierrors.FromStore(w, r, err, "error invalid request")
+		return
+	}
+	if err := ctx.UserStore.InsertRule(newRule); err != nil {
+		apierrors.FromStore(w, r, err, "error inserting new access rule into store")
+		return
+	}
+	logging.RequestLogger(w, r).WithFields(logrus.Fields{
+		"admin": admin.Principal(),
+		"rule":  newRule,
+	}).Warn("handling create rule")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newRule)
+}
+
+// DeleteRuleHandler removes the rule with the given ID and revokes the
+// sessions of the users it admitted, who sign in again through any
+// rule still admitting them.
+func (ctx *ReqCtx) DeleteRuleHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := ctx.checkAdmin(w, r)
+	if !ok {
+		return
+	}
+	path := r.PathValue("id")
+	if !bson.IsObjectIdHex(path) {
+		apierrors.Write(w, r, http.StatusBadRequest, apierrors.CodeInvalidRequest, "error path is not valid id", nil)
+		return
+	}
+	ruleID := bson.ObjectIdHex(path)
+	if err := ctx.UserStore.DeleteRule(ruleID); err != nil {
+		apierrors.FromStore(w, r, err, "error deleting access rule")
+		return
+	}
+	revoked := ctx.SessionStore.RevokeRule(ruleID)
+	if revoked > 0 {
+		event := models.NewAuditEvent(r.Context(), admin.Principal(), models.AuditSessionRevoke)
+		event.Subject = "rule:" + path
+		event.Details = map[string]interface{}{
+			"sessions": revoked,
+			"reason":   "access rule deleted",
+		}
+		ctx.audit(r, event)
+	}
+	logging.RequestLogger(w, r).WithFields(logrus.Fields{
+		"admin":    admin.Principal(),
+		"rule":     path,
+		"sessions": revoked,
+	}).Warn("handling delete rule")
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/KyleWS/blog-api/api-server/apierrors"
+	"github.com/KyleWS/blog-api/api-server/logging"
+	"github.com/KyleWS/blog-api/api-server/models"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// defaultAuditLimit is how many events are listed unless the
+	// request asks for fewer or more
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// audit records event. The change it describes has already been made,
+// so failing to record it is logged rather than sent to the client.
+func (ctx *ReqCtx) audit(r *http.Request, event *models.AuditEvent) {
+	if ctx.Audit == nil {
+		return
+	}
+	if err := ctx.Audit.AppendAudit(r.Context(), event); err != nil {
+		logging.FromContext(r.Context()).WithFields(logrus.Fields{
+			"event": event,
+			"err":   err,
+		}).Error("error recording audit event")
+	}
+}
+
+// AuditHandler lists audit events, newest first. The actor, post and
+// since (RFC 3339) query parameters narrow them down and limit caps
+// how many are returned.
+func (ctx *ReqCtx) AuditHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := ctx.checkAdmin(w, r); !ok {
+		return
+	}
+	query := r.URL.Query()
+	filter := &models.AuditFilter{
+		Actor: query.Get("actor"),
+		Limit: defaultAuditLimit,
+	}
+	if post := query.Get("post"); len(post) > 0 {
+		if !bson.IsObjectIdHex(post) {
+			apierrors.Write(w, r, http.StatusBadRequest, apierrors.CodeInvalidRequest, "error post is not valid id", nil)
+			return
+		}
+		filter.PostID = bson.ObjectIdHex(post)
+	}
+	if since := query.Get("since"); len(since) > 0 {
+		parsed, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			apierrors.Write(w, r, http.StatusBadRequest, apierrors.CodeInvalidRequest, fmt.Sprintf("error since must be an RFC 3339 time: %v", err), nil)
+			return
+		}
+		filter.Since = parsed
+	}
+	if limit := query.Get("limit"); len(limit) > 0 {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed <= 0 || parsed > maxAuditLimit {
+			apierrors.Write(w, r, http.StatusBadRequest, apierrors.CodeInvalidRequest, fmt.Sprintf("error limit must be between 1 and %d", maxAuditLimit), nil)
+			return
+		}
+		filter.Limit = parsed
+	}
+	events, err := ctx.Audit.FindAudit(r.Context(), filter)
+	if err != nil {
+		apierrors.FromStore(w, r, err, "error fetching audit events")
+		return
+	}
+	json.NewEncoder(w).Encode(events)
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/KyleWS/blog-api/api-server/apierrors"
+	"github.com/KyleWS/blog-api/api-server/logging"
+	"github.com/KyleWS/blog-api/api-server/models"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// What a bulk operation did to a post.
+const (
+	BulkUpdated   = "updated"
+	BulkTrashed   = "trashed"
+	BulkUnchanged = "unchanged"
+	BulkFailed    = "failed"
+	// BulkSkipped posts would have changed but another one failed
+	// and the request was all or nothing
+	BulkSkipped = "skipped"
+)
+
+// BulkItemResult is what a bulk operation did to one post, with why
+// when it failed.
+type BulkItemResult struct {
+	ID      string               `json:"id"`
+	Status  string               `json:"status"`
+	Code    string               `json:"code,omitempty"`
+	Message string               `json:"message,omitempty"`
+	Details []*models.FieldError `json:"details,omitempty"`
+}
+
+// BulkResult is the response to a bulk operation. Applied is false
+// unless every change that was checked was written: nothing is written
+// when the request was all or nothing and a post failed, and posts
+// edited since they were read or missed by a failed write fail.
+type BulkResult struct {
+	Applied bool              `json:"applied"`
+	Results []*BulkItemResult `json:"results"`
+}
+
+// BulkPostsHandler applies one operation to the posts listed or
+// matched by a filter in the request body. Every post is checked
+// before the changes are written together, the response says what
+// happened to each. The write is not a transaction, all_or_nothing
+// only holds off writing when a check fails.
+func (ctx *ReqCtx) BulkPostsHandler(w http.ResponseWriter, r *http.Request) {
+	// require authenticated user
+	state, err := ctx.Auth.CheckAuthToken(w, r)
+	if err != nil {
+		apierrors.Write(w, r, http.StatusUnauthorized, apierrors.CodeUnauthorized, fmt.Sprintf("error access token required: %v", err), nil)
+		return
+	}
+	req := &models.BulkPostRequest{}
+	if !ctx.decodeJSON(w, r, req) {
+		return
+	}
+	if err := req.Validate(&ctx.PostLimits, ctx.BulkMaxPosts); err != nil {
+		apierrors.FromStore(w, r, err, "error invalid bulk request")
+		return
+	}
+	// one more than allowed tells a filter matching too many apart
+	posts, err := ctx.PostStore.FindTextPosts(r.Context(), req.ObjectIDs(), req.Filter, ctx.BulkMaxPosts+1)
+	if err != nil {
+		apierrors.FromStore(w, r, err, "error finding posts")
+		return
+	}
+	if len(posts) > ctx.BulkMaxPosts {
+		problems := &models.ValidationError{}
+		problems.Add("filter", fmt.Sprintf("matches more than %d posts", ctx.BulkMaxPosts))
+		apierrors.FromStore(w, r, problems, "error invalid bulk request")
+		return
+	}
+	result, err := ctx.bulkUpdate(r, state.Principal(), req, posts)
+	if err != nil {
+		apierrors.FromStore(w, r, err, "error applying bulk operation")
+		return
+	}
+	logging.RequestLogger(w, r).WithFields(logrus.Fields{
+		"operation": req.Operation,
+		"posts":     len(result.Results),
+		"applied":   result.Applied,
+	}).Debug("handling bulk posts")
+	json.NewEncoder(w).Encode(result)
+}
+
+// bulkUpdate applies req to posts for actor, reporting on the posts in
+// the order req lists them. Posts are changed with one write to the
+// store, limited to those still as they were read, and the changes
+// that were written are recorded one by one like updatePost and
+// deletePost.
+func (ctx *ReqCtx) bulkUpdate(r *http.Request, actor string, req *models.BulkPostRequest, posts []*models.TextPost) (*BulkResult, error) {
+	// as stored, so the stamp can be compared with what is read back
+	edited := time.Now().Truncate(time.Millisecond)
+	byID := make(map[bson.ObjectId]*models.TextPost, len(posts))
+	for _, post := range posts {
+		byID[post.ID] = post
+	}
+	ids := req.ObjectIDs()
+	if len(ids) == 0 {
+		for _, post := range posts {
+			ids = append(ids, post.ID)
+		}
+	}
+
+	result := &BulkResult{Applied: true, Results: make([]*BulkItemResult, 0, len(ids))}
+	changed := make([]*models.TextPost, 0, len(ids))
+	afters := make(map[bson.ObjectId]*models.TextPost, len(ids))
+	failed := false
+	for _, id := range ids {
+		item := &BulkItemResult{ID: id.Hex(), Status: BulkUnchanged}
+		result.Results = append(result.Results, item)
+		post, found := byID[id]
+		if !found {
+			item.Status, item.Code, item.Message = BulkFailed, apierrors.CodeNotFound, "error no post with given ID"
+			failed = true
+			continue
+		}
+		after := *post
+		modified, err := req.Apply(&after, &ctx.PostLimits, edited)
+		var validationErr *models.ValidationError
+		switch {
+		case errors.As(err, &validationErr):
+			item.Status, item.Code, item.Message = BulkFailed, apierrors.CodeInvalidRequest, validationErr.Error()
+			item.Details = validationErr.Fields
+			failed = true
+		case modified && req.Operation == models.BulkTrash:
+			item.Status = BulkTrashed
+		case modified:
+			item.Status = BulkUpdated
+		}
+		if modified {
+			changed = append(changed, post)
+			afters[id] = &after
+		}
+	}
+
+	if req.AllOrNothing && failed {
+		result.Applied = false
+		for _, item := range result.Results {
+			if item.Status == BulkUpdated || item.Status == BulkTrashed {
+				item.Status = BulkSkipped
+			}
+		}
+		return result, nil
+	}
+	if len(changed) == 0 {
+		return result, nil
+	}
+	written, err := ctx.PostStore.BulkUpdateTextPosts(r.Context(), changed, req, edited)
+	if err != nil && written == nil {
+		return nil, err
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).WithField("err", err).Error("error applying bulk operation")
+	}
+	landed := make(map[bson.ObjectId]bool, len(written))
+	for _, id := range written {
+		landed[id] = true
+	}
+	for _, item := range result.Results {
+		if item.Status != BulkUpdated && item.Status != BulkTrashed {
+			continue
+		}
+		id := bson.ObjectIdHex(item.ID)
+		switch {
+		case landed[id]:
+			if req.Operation == models.BulkTrash {
+				ctx.recordDelete(r, actor, byID[id])
+			} else {
+				ctx.recordUpdate(r, actor, byID[id], afters[id])
+			}
+			continue
+		case err != nil:
+			item.Status, item.Code, item.Message = BulkFailed, apierrors.CodeUnavailable, "error writing changes, the post was left as it was"
+		default:
+			item.Status, item.Code, item.Message = BulkFailed, apierrors.CodeConflict, "error post changed since it was read, it was left as it is"
+		}
+		result.Applied = false
+	}
+	return result, nil
+}
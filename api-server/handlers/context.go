@@ -1,11 +1,35 @@
 package handlers
 
 import (
+	"time"
+
+	"github.com/KyleWS/blog-api/api-server/events"
 	"github.com/KyleWS/blog-api/api-server/models"
+	"github.com/KyleWS/blog-api/api-server/sanitize"
 	"github.com/KyleWS/blog-api/api-server/sessions"
+	"github.com/KyleWS/blog-api/api-server/webhooks"
 )
 
 type ReqCtx struct {
 	PostStore    *models.MongoStore
+	UserStore    *models.MongoUserStore
 	SessionStore *sessions.MemStore
+	Auth         *sessions.AuthContext
+	// Audit records changes to posts and who made them
+	Audit models.AuditStore
+	// Webhooks are told about changes to posts
+	Webhooks     *webhooks.Dispatcher
+	WebhookStore *models.MongoWebhookStore
+	// Events streams changes to posts, with a comment sent every
+	// EventsHeartbeat to keep idle streams open
+	Events          *events.Broker
+	EventsHeartbeat time.Duration
+	// PostLimits bounds the posts users may create, MaxRequestBytes
+	// the request bodies handlers read
+	PostLimits      models.PostLimits
+	MaxRequestBytes int64
+	// BulkMaxPosts caps the posts one bulk operation may change
+	BulkMaxPosts int
+	// Sanitizer cleans post bodies as they are saved and served
+	Sanitizer *sanitize.Sanitizer
 }
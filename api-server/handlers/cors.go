@@ -2,33 +2,173 @@ package handlers
 
 import (
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/KyleWS/blog-api/api-server/logging"
-	"gopkg.in/mgo.v2/bson"
+	"github.com/KyleWS/blog-api/api-server/apierrors"
 )
 
+// candidateMethods are the methods we probe the routes for when
+// working out what a path accepts
+var candidateMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+}
+
+// CORSPolicy decides which cross origin requests browsers may make.
+type CORSPolicy struct {
+	// AllowedOrigins lists the origins allowed to call the API, such as
+	// "https://blog.example.com". An entry may hold one "*" wildcard,
+	// as in "https://*.example.com", and "*" alone allows any origin.
+	AllowedOrigins []string
+	// AllowCredentials lets browsers send cookies with cross origin
+	// requests, it cannot be combined with the "*" origin
+	AllowCredentials bool
+	// AllowedHeaders are the request headers clients may send
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers clients may read
+	ExposedHeaders []string
+	// MaxAge is how long browsers may cache a preflight response
+	MaxAge time.Duration
+}
+
+// DefaultCORSPolicy returns the policy used when nothing is
+// configured: no cross origin callers, without credentials.
+func DefaultCORSPolicy() *CORSPolicy {
+	return &CORSPolicy{
+		AllowedHeaders: []string{"Content-Type", "Authorization", "RequestID", "X-CSRF-Token"},
+		ExposedHeaders: []string{"Authorization", "RequestID", "Session-Expires-In", "X-CSRF-Token"},
+		MaxAge:         10 * time.Minute,
+	}
+}
+
 // CORS struct contains handler that will attach proper Access-Control headers
 type CORS struct {
 	Handler http.Handler
+	// Routes is consulted to find which methods a path accepts when
+	// answering preflight requests
+	Routes *http.ServeMux
+	Policy *CORSPolicy
 }
 
-// NewCORS returns cors object with given handler assigned
-func NewCORS(handler http.Handler) *CORS {
+// NewCORS returns cors object with given handler assigned. routes is
+// the mux behind handler.
+func NewCORS(handler http.Handler, routes *http.ServeMux, policy *CORSPolicy) *CORS {
 	return &CORS{
 		Handler: handler,
+		Routes:  routes,
+		Policy:  policy,
 	}
 }
 
 func (c *CORS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("Access-Control-Allow-Origin", "*")
-	w.Header().Add("Access-Control-Allow-Methods", "GET, PUT, POST, PATCH, DELETE")
-	w.Header().Add("Access-Control-Allow-Headers", "Content-Type, Authorization, RequestID")
-	w.Header().Add("Access-Control-Expose-Headers", "Authorization, RequestID")
-	w.Header().Add("Access-Control-Max-Age", "600")
-	if r.Method != "OPTIONS" {
-		requestID := bson.NewObjectId().Hex()
-		w.Header().Add("RequestID", requestID)
-		logging.RequestLogger(w, r).Info("serving request")
-		c.Handler.ServeHTTP(w, r)
+	origin := r.Header.Get("Origin")
+	allowed := len(origin) > 0 && c.Policy.originAllowed(origin)
+	w.Header().Add("Vary", "Origin")
+
+	if r.Method == http.MethodOptions {
+		methods := c.routeMethods(r)
+		if len(methods) == 0 {
+			apierrors.Write(w, r, http.StatusNotFound, apierrors.CodeNotFound, "no such route", nil)
+			return
+		}
+		requestedMethod := r.Header.Get("Access-Control-Request-Method")
+		if allowed && len(requestedMethod) > 0 && containsFold(methods, requestedMethod) {
+			c.allowOrigin(w, origin)
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			if headers := c.Policy.requestedHeaders(r); len(headers) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+			}
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.Policy.MaxAge.Seconds())))
+		}
+		w.Header().Set("Allow", strings.Join(append(methods, http.MethodOptions), ", "))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if allowed {
+		c.allowOrigin(w, origin)
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(c.Policy.ExposedHeaders, ", "))
+	}
+	c.Handler.ServeHTTP(w, r)
+}
+
+// allowOrigin tells the browser origin may read the response.
+func (c *CORS) allowOrigin(w http.ResponseWriter, origin string) {
+	if c.Policy.anyOrigin() && !c.Policy.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.Policy.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// routeMethods returns the methods the routes accept for the path of r.
+func (c *CORS) routeMethods(r *http.Request) []string {
+	methods := make([]string, 0, len(candidateMethods))
+	for _, method := range candidateMethods {
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		if _, pattern := c.Routes.Handler(probe); len(pattern) > 0 {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// anyOrigin reports whether the policy allows every origin.
+func (p *CORSPolicy) anyOrigin() bool {
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// originAllowed reports whether origin matches one of the allowed
+// origins.
+func (p *CORSPolicy) originAllowed(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range p.AllowedOrigins {
+		allowed = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(allowed), "/"))
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		if star := strings.Index(allowed, "*"); star >= 0 {
+			prefix, suffix := allowed[:star], allowed[star+1:]
+			if len(origin) > len(prefix)+len(suffix) &&
+				strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) &&
+				!strings.ContainsAny(origin[len(prefix):len(origin)-len(suffix)], "/:") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requestedHeaders returns the headers asked for by a preflight request
+// that the policy allows.
+func (p *CORSPolicy) requestedHeaders(r *http.Request) []string {
+	var headers []string
+	for _, requested := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		requested = strings.TrimSpace(requested)
+		if len(requested) > 0 && containsFold(p.AllowedHeaders, requested) {
+			headers = append(headers, requested)
+		}
+	}
+	return headers
+}
+
+func containsFold(values []string, value string) bool {
+	for _, candidate := range values {
+		if strings.EqualFold(candidate, value) {
+			return true
+		}
 	}
+	return false
 }
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/KyleWS/blog-api/api-server/apierrors"
+	"github.com/KyleWS/blog-api/api-server/models"
+)
+
+// decodeJSON decodes the request body into v, refusing bodies over
+// MaxRequestBytes and fields v does not have. It writes the error
+// response and returns false when the body cannot be used.
+func (ctx *ReqCtx) decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, ctx.MaxRequestBytes))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err == nil {
+		return true
+	}
+	if writeTooLarge(w, r, err) {
+		return false
+	}
+	problems := &models.ValidationError{}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && len(typeErr.Field) > 0 {
+		problems.Add(typeErr.Field, fmt.Sprintf("cannot be a JSON %s", typeErr.Value))
+	}
+	// encoding/json has no error type for unknown fields
+	if field, found := strings.CutPrefix(err.Error(), "json: unknown field "); found {
+		problems.Add(strings.Trim(field, `"`), "is not a known field")
+	}
+	if len(problems.Fields) > 0 {
+		apierrors.FromStore(w, r, problems, "error invalid request")
+		return false
+	}
+	apierrors.Write(w, r, http.StatusBadRequest, apierrors.CodeInvalidRequest, fmt.Sprintf("error decoding received json: %v", err), nil)
+	return false
+}
+
+// readBody reads the request body, refusing bodies over
+// MaxRequestBytes. It writes the error response and returns false when
+// the body cannot be read.
+func (ctx *ReqCtx) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, ctx.MaxRequestBytes))
+	if err == nil {
+		return body, true
+	}
+	if !writeTooLarge(w, r, err) {
+		apierrors.Write(w, r, http.StatusBadRequest, apierrors.CodeInvalidRequest, fmt.Sprintf("error reading request body: %v", err), nil)
+	}
+	return nil, false
+}
+
+// writeTooLarge sends the response for err if it says the request body
+// was over the limit, reporting whether it did.
+func writeTooLarge(w http.ResponseWriter, r *http.Request, err error) bool {
+	var tooLarge *http.MaxBytesError
+	if !errors.As(err, &tooLarge) {
+		return false
+	}
+	apierrors.Write(w, r, http.StatusRequestEntityTooLarge, apierrors.CodeTooLarge, fmt.Sprintf("error request body is over %d bytes", tooLarge.Limit), nil)
+	return true
+}
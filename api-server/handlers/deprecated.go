@@ -0,0 +1,18 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Deprecated wraps a legacy route so its responses carry a Deprecation
+// header and a Link to the route replacing it. A {id} in successor is
+// filled in from the request's id path parameter.
+func Deprecated(successor string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		link := strings.Replace(successor, "{id}", r.PathValue("id"), 1)
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+link+`>; rel="successor-version"`)
+		handler(w, r)
+	}
+}
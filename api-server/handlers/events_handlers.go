@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/KyleWS/blog-api/api-server/apierrors"
+	"github.com/KyleWS/blog-api/api-server/events"
+	"github.com/KyleWS/blog-api/api-server/logging"
+	"github.com/KyleWS/blog-api/api-server/models"
+)
+
+const (
+	// eventReset tells a client that events it has not seen were
+	// forgotten, so it should fetch the posts again
+	eventReset = "reset"
+	// eventsRetry is how long browsers wait before reconnecting
+	eventsRetry = 3 * time.Second
+)
+
+// publish tells the webhooks subscribed to event, one of the
+// models.WebhookPost events, and clients streaming events that actor
+// changed before into post. before is nil for new posts.
+func (ctx *ReqCtx) publish(r *http.Request, event string, actor string, before *models.TextPost, post *models.TextPost) {
+	if ctx.Webhooks != nil {
+		ctx.Webhooks.Publish(r.Context(), event, actor, post)
+	}
+	if ctx.Events != nil {
+		ctx.Events.Publish(event, before, post)
+	}
+}
+
+// EventsHandler streams every change to posts as Server-Sent Events to
+// signed in users, until their session ends or is revoked.
+func (ctx *ReqCtx) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	if _, err := ctx.Auth.CheckAuthToken(w, r); err != nil {
+		apierrors.Write(w, r, http.StatusUnauthorized, apierrors.CodeUnauthorized, fmt.Sprintf("error access token required: %v", err), nil)
+		return
+	}
+	ctx.streamEvents(w, r, false, func() bool {
+		// looking the session up leaves its idle timeout alone, so an
+		// open stream does not keep an idle session alive
+		_, ok := ctx.Auth.SessionPrincipal(r)
+		return ok
+	})
+}
+
+// PublicEventsHandler streams changes to published posts as
+// Server-Sent Events to anyone.
+func (ctx *ReqCtx) PublicEventsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx.streamEvents(w, r, true, nil)
+}
+
+// streamEvents sends events until the client goes away or the server
+// shuts down, starting with those published after the one named in the
+// Last-Event-ID header. When signedIn is set it is called on every
+// heartbeat and the stream ends once it reports the session is gone.
+func (ctx *ReqCtx) streamEvents(w http.ResponseWriter, r *http.Request, public bool, signedIn func() bool) {
+	controller := http.NewResponseController(w)
+	// the stream outlives the server's write timeout
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil {
+		logging.RequestLogger(w, r).WithField("err", err).Warn("error lifting write deadline for event stream")
+	}
+	sub, missed, ok := ctx.Events.Subscribe(r.Header.Get("Last-Event-ID"), public)
+	defer ctx.Events.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// stops nginx holding events back
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", eventsRetry.Milliseconds())
+	if !ok {
+		fmt.Fprintf(w, "event: %s\ndata: {}\n\n", eventReset)
+	}
+	for _, event := range missed {
+		writeEvent(w, event)
+	}
+	if err := controller.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(ctx.EventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, open := <-sub.C:
+			if !open {
+				return
+			}
+			writeEvent(w, event)
+		case <-heartbeat.C:
+			if signedIn != nil && !signedIn() {
+				logging.RequestLogger(w, r).Info("ending event stream of a session that ended")
+				return
+			}
+			// a comment, keeps proxies from closing an idle stream
+			io.WriteString(w, ": heartbeat\n\n")
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeEvent(w io.Writer, event *events.Event) {
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+}
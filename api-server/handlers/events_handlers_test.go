@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/KyleWS/blog-api/api-server/events"
+)
+
+func TestEventStreamEndsWithSession(t *testing.T) {
+	ctx := &ReqCtx{Events: events.NewBroker(10), EventsHeartbeat: 10 * time.Millisecond}
+	var signedIn atomic.Bool
+	signedIn.Store(true)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/v1/events", nil)
+	done := make(chan struct{})
+	go func() {
+		ctx.streamEvents(w, r, false, signedIn.Load)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("stream ended while the session was valid")
+	case <-time.After(50 * time.Millisecond):
+	}
+	signedIn.Store(false)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("stream still open after the session ended")
+	}
+	if !strings.Contains(w.Body.String(), ": heartbeat") {
+		t.Errorf("got no heartbeats before the session ended: %q", w.Body)
+	}
+}
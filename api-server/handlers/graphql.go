@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/KyleWS/blog-api/api-server/apierrors"
+	"github.com/KyleWS/blog-api/api-server/logging"
+	graphql "github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	"github.com/graph-gophers/graphql-go/trace/otel"
+)
+
+const (
+	// defaultPageSize is how many posts a page holds unless the query
+	// asks for fewer or more, up to maxPageSize
+	defaultPageSize = 20
+	maxPageSize     = 100
+	// defaultTagPageSize is how many tags or authors are listed unless
+	// the query asks for fewer or more, up to maxTagPageSize
+	defaultTagPageSize = 50
+	maxTagPageSize     = 500
+	// graphqlMaxQueryLength caps the length of the query itself
+	graphqlMaxQueryLength = 16 << 10
+)
+
+const graphqlSchema = `
+schema {
+	query: Query
+	mutation: Mutation
+}
+
+scalar Time
+
+type Query {
+	# the post with the given ID, drafts included
+	post(id: ID!): Post
+	# posts newest first, drafts only when signed in
+	posts(first: Int = 20, after: String, tag: String, author: String): PostConnection!
+	# tags by how many posts have them
+	tags(first: Int = 50): [Tag!]!
+	tag(name: String!): Tag
+	# authors by how many posts they wrote
+	authors(first: Int = 50): [Author!]!
+	author(name: String!): Author
+}
+
+type Mutation {
+	createPost(input: CreatePostInput!): Post!
+	updatePost(id: ID!, input: UpdatePostInput!): Post!
+	# returns the ID of the deleted post
+	deletePost(id: ID!): ID!
+}
+
+type Post {
+	id: ID!
+	author: Author!
+	title: String!
+	created: Time!
+	edited: Time
+	publish: Time
+	draftmode: Boolean!
+	body: String!
+	tags: [Tag!]!
+	views: Int!
+	# what was taken out of the body, only on posts returned by mutations
+	sanitized: [Removal!]
+}
+
+type Removal {
+	element: String!
+	# null when the whole element was removed
+	attribute: String
+	count: Int!
+}
+
+type Tag {
+	name: String!
+	postCount: Int!
+	posts(first: Int = 20, after: String): PostConnection!
+}
+
+type Author {
+	name: String!
+	postCount: Int!
+	posts(first: Int = 20, after: String): PostConnection!
+}
+
+type PostConnection {
+	nodes: [Post!]!
+	totalCount: Int!
+	pageInfo: PageInfo!
+}
+
+type PageInfo {
+	# pass as after to fetch the next page
+	endCursor: String
+	hasNextPage: Boolean!
+}
+
+input CreatePostInput {
+	author: String!
+	title: String!
+	body: String!
+	publish: Time
+	draftmode: Boolean! = true
+	tags: [String!]
+}
+
+input UpdatePostInput {
+	title: String
+	body: String
+	publish: Time
+	draftmode: Boolean
+	tags: [String!]
+}
+`
+
+// GraphQL serves queries and mutations over posts, tags and authors.
+// Queries deeper than the schema allows or costing more than MaxCost
+// are refused before anything is fetched.
+type GraphQL struct {
+	ctx    *ReqCtx
+	schema *graphql.Schema
+	// MaxCost caps the number of fields a query may resolve, counting
+	// every item a list could return
+	MaxCost int
+}
+
+// NewGraphQL returns the GraphQL endpoint for ctx, refusing queries
+// nested more than maxDepth deep or costing more than maxCost.
+func NewGraphQL(ctx *ReqCtx, maxDepth int, maxCost int) (*GraphQL, error) {
+	schema, err := graphql.ParseSchema(graphqlSchema, &graphqlResolver{ctx: ctx},
+		graphql.MaxDepth(maxDepth),
+		graphql.MaxQueryLength(graphqlMaxQueryLength),
+		graphql.Tracer(otel.DefaultTracer()),
+		graphql.Logger(graphqlPanicLogger{}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing graphql schema: %v", err)
+	}
+	return &GraphQL{
+		ctx:     ctx,
+		schema:  schema,
+		MaxCost: maxCost,
+	}, nil
+}
+
+// graphqlPanicLogger logs panics in resolvers with the request they
+// happened in.
+type graphqlPanicLogger struct{}
+
+func (graphqlPanicLogger) LogPanic(ctx context.Context, value interface{}) {
+	logging.FromContext(ctx).WithField("panic", value).Error("error resolving graphql query")
+}
+
+// GraphQLParams is the body of a GraphQL request.
+type GraphQLParams struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// ServeHTTP runs the query in the request body. Mutations need the
+// same access token as the REST API, queries without one see no
+// drafts.
+func (g *GraphQL) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	params := &GraphQLParams{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, g.ctx.MaxRequestBytes)).Decode(params); err != nil {
+		apierrors.Write(w, r, http.StatusBadRequest, apierrors.CodeInvalidRequest, fmt.Sprintf("error decoding received json: %v", err), nil)
+		return
+	}
+	if len(params.Query) == 0 {
+		apierrors.Write(w, r, http.StatusBadRequest, apierrors.CodeInvalidRequest, "error query is required", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if errs := g.schema.ValidateWithVariables(params.Query, params.Variables); len(errs) > 0 {
+		json.NewEncoder(w).Encode(&graphql.Response{Errors: errs})
+		return
+	}
+	cost, err := graphqlCost(params.Query, params.OperationName, params.Variables, g.ctx.PostLimits.MaxTags)
+	if err == nil && cost > g.MaxCost {
+		err = fmt.Errorf("error query cost %d is over the limit of %d", cost, g.MaxCost)
+	}
+	if err != nil {
+		json.NewEncoder(w).Encode(&graphql.Response{Errors: []*gqlerrors.QueryError{{
+			Message:    err.Error(),
+			Extensions: map[string]interface{}{"code": apierrors.CodeInvalidRequest},
+		}}})
+		return
+	}
+
+	req := &graphqlRequest{r: r}
+	state, authErr := g.ctx.Auth.CheckAuthToken(w, r)
+	if authErr == nil {
+		req.state = state
+	} else {
+		req.authErr = authErr
+	}
+	ctx := context.WithValue(r.Context(), graphqlRequestKey{}, req)
+	response := g.schema.Exec(ctx, params.Query, params.OperationName, params.Variables)
+	json.NewEncoder(w).Encode(response)
+}
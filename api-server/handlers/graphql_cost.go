@@ -0,0 +1,443 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// graphqlListSize is how many items a list returns when the query does
+// not pass first, and the most first may ask for.
+type graphqlListSize struct {
+	first int
+	max   int
+}
+
+// graphqlRootLists are the top level fields returning lists. Anything
+// they select is counted once per item.
+var graphqlRootLists = map[string]graphqlListSize{
+	"tags":    {defaultTagPageSize, maxTagPageSize},
+	"authors": {defaultTagPageSize, maxTagPageSize},
+}
+
+// graphqlConnections are the fields returning a page of posts, wherever
+// they appear. What the nodes of the page select is counted once per
+// post the page may hold.
+var graphqlConnections = map[string]graphqlListSize{
+	"posts": {defaultPageSize, maxPageSize},
+}
+
+// graphqlMaxCost is where costs stop growing, so deep queries cannot
+// overflow into a small or negative cost
+const graphqlMaxCost = math.MaxInt32
+
+// graphqlSelection is a field, fragment spread or inline fragment of
+// a parsed query, with only what is needed to work out its cost.
+type graphqlSelection struct {
+	field    string
+	first    *graphqlValue
+	spread   string
+	children []*graphqlSelection
+}
+
+// graphqlValue is an argument value, either a literal or a variable.
+type graphqlValue struct {
+	literal  string
+	variable string
+}
+
+// graphqlOperation is a query, mutation or subscription of a parsed
+// query document, with the defaults of its variables.
+type graphqlOperation struct {
+	defaults   map[string]*graphqlValue
+	selections []*graphqlSelection
+}
+
+// graphqlQuery is a parsed query document.
+type graphqlQuery struct {
+	operations map[string]*graphqlOperation
+	fragments  map[string][]*graphqlSelection
+}
+
+// graphqlCost estimates how many fields executing operationName in
+// query resolves, assuming lists are as long as they are allowed to
+// be and posts have maxTags tags. It fails when first is outside what
+// a list allows. The query must already have passed validation.
+func graphqlCost(query string, operationName string, variables map[string]interface{}, maxTags int) (int, error) {
+	parsed, err := parseGraphQLQuery(query)
+	if err != nil {
+		return 0, err
+	}
+	operation, found := parsed.operations[operationName]
+	if !found && len(operationName) == 0 && len(parsed.operations) == 1 {
+		for _, only := range parsed.operations {
+			operation, found = only, true
+		}
+	}
+	if !found {
+		return 0, fmt.Errorf("error unknown operation %q", operationName)
+	}
+	// variables left out take the defaults the operation declares
+	values := make(map[string]interface{}, len(operation.defaults)+len(variables))
+	for name, value := range operation.defaults {
+		if number, err := strconv.Atoi(value.literal); err == nil {
+			values[name] = float64(number)
+		}
+	}
+	for name, value := range variables {
+		values[name] = value
+	}
+	costing := &graphqlCosting{
+		fragments: parsed.fragments,
+		variables: values,
+		maxTags:   maxTags,
+		expanding: map[string]bool{},
+	}
+	return costing.cost(operation.selections, true, 1)
+}
+
+// graphqlCosting is what working out the cost of an operation needs
+// besides its selections.
+type graphqlCosting struct {
+	fragments map[string][]*graphqlSelection
+	variables map[string]interface{}
+	maxTags   int
+	// expanding are the fragments being costed, so a fragment
+	// spreading itself is not followed forever
+	expanding map[string]bool
+}
+
+// cost adds up the cost of selections, up to graphqlMaxCost. root is
+// set for the top level of the operation and nodes is how many posts
+// the enclosing page may hold.
+func (c *graphqlCosting) cost(selections []*graphqlSelection, root bool, nodes int) (int, error) {
+	total := 0
+	for _, selection := range selections {
+		var cost int
+		var err error
+		switch {
+		case len(selection.spread) > 0:
+			if c.expanding[selection.spread] {
+				continue
+			}
+			c.expanding[selection.spread] = true
+			cost, err = c.cost(c.fragments[selection.spread], root, nodes)
+			delete(c.expanding, selection.spread)
+		case len(selection.field) == 0:
+			// an inline fragment
+			cost, err = c.cost(selection.children, root, nodes)
+		default:
+			items, pageSize := 1, 1
+			if size, isList := graphqlRootLists[selection.field]; isList && root {
+				items, err = c.first(selection, size)
+			} else if size, isPage := graphqlConnections[selection.field]; isPage {
+				pageSize, err = c.first(selection, size)
+			} else if selection.field == "nodes" {
+				items = nodes
+			} else if selection.field == "tags" {
+				// the tags of a post
+				items = c.maxTags
+			}
+			if err != nil {
+				return 0, err
+			}
+			var children int
+			children, err = c.cost(selection.children, false, pageSize)
+			cost = 1 + items*children
+		}
+		if err != nil {
+			return 0, err
+		}
+		total = min(total+cost, graphqlMaxCost)
+	}
+	return total, nil
+}
+
+// first returns how many items selection asks for, failing like the
+// resolvers do when that is outside what size allows.
+func (c *graphqlCosting) first(selection *graphqlSelection, size graphqlListSize) (int, error) {
+	first := selection.first.intOr(c.variables, size.first)
+	if first < 1 || first > size.max {
+		return 0, fmt.Errorf("error first of %s must be between 1 and %d", selection.field, size.max)
+	}
+	return first, nil
+}
+
+// intOr returns the value of v as an int, or otherwise if it is not
+// set or not an int.
+func (v *graphqlValue) intOr(variables map[string]interface{}, otherwise int) int {
+	if v == nil {
+		return otherwise
+	}
+	if len(v.variable) > 0 {
+		// JSON numbers decode as float64
+		if number, ok := variables[v.variable].(float64); ok {
+			return int(number)
+		}
+		return otherwise
+	}
+	if number, err := strconv.Atoi(v.literal); err == nil {
+		return number
+	}
+	return otherwise
+}
+
+// graphqlParser reads the parts of a query document that affect its
+// cost, skipping directives, variable types and other arguments.
+type graphqlParser struct {
+	tokens []string
+	pos    int
+}
+
+func parseGraphQLQuery(query string) (parsed *graphqlQuery, err error) {
+	tokens, err := lexGraphQL(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &graphqlParser{tokens: tokens}
+	parsed = &graphqlQuery{
+		operations: map[string]*graphqlOperation{},
+		fragments:  map[string][]*graphqlSelection{},
+	}
+	defer func() {
+		// the parser panics on running out of tokens
+		if recovered := recover(); recovered != nil {
+			parsed, err = nil, fmt.Errorf("error parsing query: %v", recovered)
+		}
+	}()
+	for p.pos < len(p.tokens) {
+		switch p.peek() {
+		case "{":
+			parsed.operations[""] = &graphqlOperation{selections: p.selectionSet()}
+		case "fragment":
+			p.next()
+			name := p.next()
+			p.expect("on")
+			p.next()
+			p.skipDirectives()
+			parsed.fragments[name] = p.selectionSet()
+		case "query", "mutation", "subscription":
+			p.next()
+			name := ""
+			if p.peek() != "(" && p.peek() != "{" && p.peek() != "@" {
+				name = p.next()
+			}
+			operation := &graphqlOperation{defaults: map[string]*graphqlValue{}}
+			if p.peek() == "(" {
+				operation.defaults = p.variableDefaults()
+			}
+			p.skipDirectives()
+			operation.selections = p.selectionSet()
+			parsed.operations[name] = operation
+		default:
+			return nil, fmt.Errorf("error parsing query: unexpected %q", p.peek())
+		}
+	}
+	return parsed, nil
+}
+
+func (p *graphqlParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *graphqlParser) next() string {
+	if p.pos >= len(p.tokens) {
+		panic("unexpected end of query")
+	}
+	p.pos++
+	return p.tokens[p.pos-1]
+}
+
+func (p *graphqlParser) expect(token string) {
+	if found := p.next(); found != token {
+		panic(fmt.Sprintf("expected %q, found %q", token, found))
+	}
+}
+
+// skipBalanced skips from open to the close matching it.
+func (p *graphqlParser) skipBalanced(open string, close string) {
+	p.expect(open)
+	for depth := 1; depth > 0; {
+		switch p.next() {
+		case open:
+			depth++
+		case close:
+			depth--
+		}
+	}
+}
+
+func (p *graphqlParser) skipDirectives() {
+	for p.peek() == "@" {
+		p.next()
+		p.next()
+		if p.peek() == "(" {
+			p.skipBalanced("(", ")")
+		}
+	}
+}
+
+func (p *graphqlParser) selectionSet() []*graphqlSelection {
+	p.expect("{")
+	var selections []*graphqlSelection
+	for p.peek() != "}" {
+		selections = append(selections, p.selection())
+	}
+	p.next()
+	return selections
+}
+
+func (p *graphqlParser) selection() *graphqlSelection {
+	if p.peek() == "..." {
+		p.next()
+		if p.peek() == "on" || p.peek() == "{" || p.peek() == "@" {
+			if p.peek() == "on" {
+				p.next()
+				p.next()
+			}
+			p.skipDirectives()
+			return &graphqlSelection{children: p.selectionSet()}
+		}
+		spread := &graphqlSelection{spread: p.next()}
+		p.skipDirectives()
+		return spread
+	}
+	selection := &graphqlSelection{field: p.next()}
+	if p.peek() == ":" {
+		// what came first was the alias
+		p.next()
+		selection.field = p.next()
+	}
+	if p.peek() == "(" {
+		selection.first = p.firstArgument()
+	}
+	p.skipDirectives()
+	if p.peek() == "{" {
+		selection.children = p.selectionSet()
+	}
+	return selection
+}
+
+// variableDefaults reads the variable definitions of an operation,
+// returning the default values of those that have one.
+func (p *graphqlParser) variableDefaults() map[string]*graphqlValue {
+	defaults := map[string]*graphqlValue{}
+	p.expect("(")
+	for p.peek() != ")" {
+		p.expect("$")
+		name := p.next()
+		p.expect(":")
+		// the type, a name or a list, either of which may be non-null
+		if p.peek() == "[" {
+			p.skipBalanced("[", "]")
+		} else {
+			p.next()
+		}
+		if p.peek() == "!" {
+			p.next()
+		}
+		if p.peek() == "=" {
+			p.next()
+			defaults[name] = p.value()
+		}
+		p.skipDirectives()
+	}
+	p.next()
+	return defaults
+}
+
+// firstArgument reads an argument list, returning the value of first
+// if it is there.
+func (p *graphqlParser) firstArgument() *graphqlValue {
+	var first *graphqlValue
+	p.expect("(")
+	for p.peek() != ")" {
+		name := p.next()
+		p.expect(":")
+		value := p.value()
+		if name == "first" {
+			first = value
+		}
+	}
+	p.next()
+	return first
+}
+
+// value reads an argument value, lists and objects are skipped.
+func (p *graphqlParser) value() *graphqlValue {
+	switch p.peek() {
+	case "$":
+		p.next()
+		return &graphqlValue{variable: p.next()}
+	case "[":
+		p.skipBalanced("[", "]")
+		return &graphqlValue{}
+	case "{":
+		p.skipBalanced("{", "}")
+		return &graphqlValue{}
+	}
+	return &graphqlValue{literal: p.next()}
+}
+
+// lexGraphQL splits query into names, numbers, strings and
+// punctuation, dropping whitespace, commas and comments.
+func lexGraphQL(query string) ([]string, error) {
+	var tokens []string
+	query = strings.TrimPrefix(query, "\uFEFF")
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(query[i:], "..."):
+			tokens = append(tokens, "...")
+			i += 3
+		case strings.HasPrefix(query[i:], `"""`):
+			end := strings.Index(query[i+3:], `"""`)
+			if end < 0 {
+				return nil, fmt.Errorf("error parsing query: unterminated string")
+			}
+			tokens = append(tokens, query[i:i+3+end+3])
+			i += 3 + end + 3
+		case c == '"':
+			j := i + 1
+			for j < len(query) && query[j] != '"' {
+				if query[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(query) {
+				return nil, fmt.Errorf("error parsing query: unterminated string")
+			}
+			tokens = append(tokens, query[i:j+1])
+			i = j + 1
+		case isNameByte(c) || c == '-':
+			// names and numbers, which may have a sign, fraction and
+			// exponent
+			j := i + 1
+			for j < len(query) && (isNameByte(query[j]) || query[j] == '.' ||
+				((query[j] == '-' || query[j] == '+') && (query[j-1] == 'e' || query[j-1] == 'E'))) {
+				j++
+			}
+			tokens = append(tokens, query[i:j])
+			i = j
+		default:
+			tokens = append(tokens, string(c))
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+func isNameByte(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
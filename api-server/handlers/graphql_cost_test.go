@@ -0,0 +1,117 @@
+package handlers
+
+import "testing"
+
+// testMaxTags is how many tags the posts in these tests may have
+const testMaxTags = 10
+
+func TestGraphQLCost(t *testing.T) {
+	cases := []struct {
+		name          string
+		query         string
+		operationName string
+		variables     map[string]interface{}
+		want          int
+	}{
+		{
+			name:  "literal first",
+			query: `{ tags(first: 2) { name } }`,
+			want:  1 + 2*1,
+		},
+		{
+			name:  "page sizes when first is left out",
+			query: `{ tags { posts { nodes { body } } } }`,
+			want:  1 + defaultTagPageSize*(1+(1+defaultPageSize*1)),
+		},
+		{
+			name:  "variable defaults",
+			query: `query($n: Int = 100){ tags(first:$n){ posts(first:$n){ nodes{ body } } } }`,
+			want:  1 + 100*(1+(1+100*1)),
+		},
+		{
+			name:      "variables override defaults",
+			query:     `query($n: Int = 100){ tags(first:$n){ posts(first:$n){ nodes{ body } } } }`,
+			variables: map[string]interface{}{"n": float64(2)},
+			want:      1 + 2*(1+(1+2*1)),
+		},
+		{
+			name:          "list and non-null variable types with directives",
+			query:         `query Other { tags { name } } query Mine($tags: [String!]! = ["a", "b"], $n: Int! = 3 @deprecated) @live { tags(first: $n) { name } }`,
+			operationName: "Mine",
+			want:          1 + 3*1,
+		},
+		{
+			name:  "variable without a default",
+			query: `query($n: Int) { authors(first: $n) { name } }`,
+			want:  1 + defaultTagPageSize*1,
+		},
+		{
+			name:  "aliases, fragments and inline fragments",
+			query: `query { mine: tags(first: 1) { ...Tag } } fragment Tag on Tag { name ... on Tag { count } }`,
+			want:  1 + 1*(1+1),
+		},
+		{
+			name:  "recursive fragment",
+			query: `{ ...Loop } fragment Loop on Query { tags(first: 1) { name } ...Loop }`,
+			want:  1 + 1,
+		},
+		{
+			name:  "fields outside lists count once",
+			query: `{ post(id: "1") { title } }`,
+			want:  1 + 1,
+		},
+		{
+			name:  "the tags of a post count as many as a post may have",
+			query: `{ posts(first: 2) { nodes { tags { posts(first: 3) { nodes { body } } } } } }`,
+			want:  1 + (1 + 2*(1+testMaxTags*(1+(1+3*1)))),
+		},
+		{
+			name:  "deep queries stop at the most a cost can be",
+			query: `{ tags(first: 500) { posts(first: 100) { nodes { tags { posts(first: 100) { nodes { tags { posts(first: 100) { nodes { tags { posts(first: 100) { nodes { body } } } } } } } } } } } } }`,
+			want:  graphqlMaxCost,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cost, err := graphqlCost(c.query, c.operationName, c.variables, testMaxTags)
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if cost != c.want {
+				t.Errorf("got cost %d, want %d", cost, c.want)
+			}
+		})
+	}
+}
+
+func TestGraphQLCostErrors(t *testing.T) {
+	cases := []struct {
+		name          string
+		query         string
+		operationName string
+		variables     map[string]interface{}
+	}{
+		{name: "unterminated selection", query: `{ tags { name }`},
+		{name: "unterminated variables", query: `query($n: Int = 3 { tags { name } }`},
+		{name: "unterminated string", query: `{ post(id: "1) { title } }`},
+		{name: "unknown operation", query: `query A { tags { name } }`, operationName: "B"},
+		{name: "operation name required", query: `query A { tags { name } } query B { authors { name } }`},
+		{name: "negative first", query: `{ a: tags(first: -100000) { name } b: tags(first: 100) { posts(first: 100) { nodes { body } } } }`},
+		{name: "zero first", query: `{ posts(first: 0) { nodes { body } } }`},
+		{name: "first over the most a page holds", query: `{ posts(first: 101) { nodes { body } } }`},
+		{name: "first over the most tags listed", query: `{ authors(first: 501) { name } }`},
+		{
+			name:      "negative first in a variable",
+			query:     `query($n: Int) { tags(first: $n) { name } }`,
+			variables: map[string]interface{}{"n": float64(-5)},
+		},
+		{name: "negative first as a default", query: `query($n: Int = -5) { tags { posts(first: $n) { totalCount } } }`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := graphqlCost(c.query, c.operationName, c.variables, testMaxTags); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
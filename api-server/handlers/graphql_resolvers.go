@@ -0,0 +1,561 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/KyleWS/blog-api/api-server/apierrors"
+	"github.com/KyleWS/blog-api/api-server/logging"
+	"github.com/KyleWS/blog-api/api-server/models"
+	"github.com/KyleWS/blog-api/api-server/sanitize"
+	"github.com/KyleWS/blog-api/api-server/sessions"
+	graphql "github.com/graph-gophers/graphql-go"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// graphqlError is an error shown to clients, with the same codes as
+// the REST API in its extensions.
+type graphqlError struct {
+	message string
+	code    string
+}
+
+func (e *graphqlError) Error() string {
+	return e.message
+}
+
+func (e *graphqlError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": e.code}
+}
+
+// graphqlStoreError is the error shown for an error returned by one of
+// the stores, matching what apierrors.FromStore would send.
+func graphqlStoreError(ctx context.Context, err error, message string) error {
+	var validationErr *models.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		return &graphqlError{validationErr.Error(), apierrors.CodeInvalidRequest}
+	case errors.Is(err, models.ErrNotFound):
+		return &graphqlError{message + ": not found", apierrors.CodeNotFound}
+	case errors.Is(err, models.ErrConflict):
+		return &graphqlError{err.Error(), apierrors.CodeConflict}
+	case errors.Is(err, models.ErrUnavailable):
+		logging.FromContext(ctx).WithField("err", err).Error("error handling request")
+		return &graphqlError{message + ": database unavailable", apierrors.CodeUnavailable}
+	}
+	logging.FromContext(ctx).WithField("err", err).Error("error handling request")
+	return &graphqlError{message, apierrors.CodeInternal}
+}
+
+type graphqlRequestKey struct{}
+
+// graphqlRequest is what resolvers share while answering one request:
+// who is asking and the posts they may see, fetched at most once.
+type graphqlRequest struct {
+	r       *http.Request
+	state   *sessions.SessionState
+	authErr error
+
+	lock    sync.Mutex
+	posts   []*models.PostShort
+	fetched bool
+}
+
+func requestFrom(ctx context.Context) *graphqlRequest {
+	return ctx.Value(graphqlRequestKey{}).(*graphqlRequest)
+}
+
+// allPosts returns every post the request may see, newest first.
+// Drafts are only included for authenticated users.
+func (req *graphqlRequest) allPosts(ctx context.Context, store *models.MongoStore) ([]*models.PostShort, error) {
+	req.lock.Lock()
+	defer req.lock.Unlock()
+	if req.fetched {
+		return req.posts, nil
+	}
+	posts, err := store.FetchAllShort(ctx, req.state != nil)
+	if err != nil {
+		return nil, graphqlStoreError(ctx, err, "error fetching all posts")
+	}
+	sort.SliceStable(posts, func(i, j int) bool {
+		return posts[i].Created.After(posts[j].Created)
+	})
+	req.posts, req.fetched = posts, true
+	return posts, nil
+}
+
+// forget drops the posts fetched so far, mutations call it so what they
+// return reflects their change.
+func (req *graphqlRequest) forget() {
+	req.lock.Lock()
+	defer req.lock.Unlock()
+	req.posts, req.fetched = nil, false
+}
+
+// requireAuth returns the session of the user making the request,
+// failing like the REST API when there is none.
+func (req *graphqlRequest) requireAuth() (*sessions.SessionState, error) {
+	if req.state == nil {
+		return nil, &graphqlError{fmt.Sprintf("error access token required: %v", req.authErr), apierrors.CodeUnauthorized}
+	}
+	return req.state, nil
+}
+
+// graphqlPostID returns id as a post ID.
+func graphqlPostID(id graphql.ID) (bson.ObjectId, error) {
+	if !bson.IsObjectIdHex(string(id)) {
+		return "", &graphqlError{"error id is not valid id", apierrors.CodeInvalidRequest}
+	}
+	return bson.ObjectIdHex(string(id)), nil
+}
+
+// graphqlLimit checks first is between 1 and max.
+func graphqlLimit(first int32, max int) (int, error) {
+	if first <= 0 || int(first) > max {
+		return 0, &graphqlError{fmt.Sprintf("error first must be between 1 and %d", max), apierrors.CodeInvalidRequest}
+	}
+	return int(first), nil
+}
+
+// graphqlResolver resolves the Query and Mutation types.
+type graphqlResolver struct {
+	ctx *ReqCtx
+}
+
+type postsArgs struct {
+	First  int32
+	After  *string
+	Tag    *string
+	Author *string
+}
+
+type pageArgs struct {
+	First int32
+	After *string
+}
+
+func (gr *graphqlResolver) Post(ctx context.Context, args struct{ ID graphql.ID }) (*postResolver, error) {
+	postID, err := graphqlPostID(args.ID)
+	if err != nil {
+		return nil, err
+	}
+	post, err := gr.ctx.PostStore.GetTextPostByID(ctx, postID)
+	if errors.Is(err, models.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, graphqlStoreError(ctx, err, "error cannot find post with given ID")
+	}
+	return &postResolver{ctx: gr.ctx, short: post.Short(), full: post}, nil
+}
+
+func (gr *graphqlResolver) Posts(ctx context.Context, args postsArgs) (*connectionResolver, error) {
+	group := &postGroup{ctx: gr.ctx}
+	if args.Tag != nil || args.Author != nil {
+		group.matches = func(post *models.PostShort) bool {
+			return (args.Tag == nil || hasTag(post, *args.Tag)) &&
+				(args.Author == nil || post.Author == *args.Author)
+		}
+	}
+	return group.Posts(ctx, pageArgs{First: args.First, After: args.After})
+}
+
+func (gr *graphqlResolver) Tags(ctx context.Context, args struct{ First int32 }) ([]*postGroup, error) {
+	return gr.groups(ctx, args.First, func(post *models.PostShort) []string {
+		return post.Tags
+	}, tagGroup)
+}
+
+func (gr *graphqlResolver) Tag(ctx context.Context, args struct{ Name string }) (*postGroup, error) {
+	return gr.existing(ctx, tagGroup(gr.ctx, args.Name))
+}
+
+func (gr *graphqlResolver) Authors(ctx context.Context, args struct{ First int32 }) ([]*postGroup, error) {
+	return gr.groups(ctx, args.First, func(post *models.PostShort) []string {
+		return []string{post.Author}
+	}, authorGroup)
+}
+
+func (gr *graphqlResolver) Author(ctx context.Context, args struct{ Name string }) (*postGroup, error) {
+	return gr.existing(ctx, authorGroup(gr.ctx, args.Name))
+}
+
+// groups returns the first names found by namesOf in the visible
+// posts, those on the most posts first.
+func (gr *graphqlResolver) groups(ctx context.Context, first int32, namesOf func(*models.PostShort) []string, group func(*ReqCtx, string) *postGroup) ([]*postGroup, error) {
+	limit, err := graphqlLimit(first, maxTagPageSize)
+	if err != nil {
+		return nil, err
+	}
+	posts, err := requestFrom(ctx).allPosts(ctx, gr.ctx.PostStore)
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	for _, post := range posts {
+		for _, name := range namesOf(post) {
+			counts[name]++
+		}
+	}
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	groups := make([]*postGroup, 0, min(limit, len(names)))
+	for _, name := range names[:min(limit, len(names))] {
+		groups = append(groups, group(gr.ctx, name))
+	}
+	return groups, nil
+}
+
+// existing returns group if any visible post belongs to it, nil
+// otherwise.
+func (gr *graphqlResolver) existing(ctx context.Context, group *postGroup) (*postGroup, error) {
+	count, err := group.PostCount(ctx)
+	if err != nil || count == 0 {
+		return nil, err
+	}
+	return group, nil
+}
+
+type createPostArgs struct {
+	Input struct {
+		Author    string
+		Title     string
+		Body      string
+		Publish   *graphql.Time
+		Draftmode bool
+		Tags      *[]string
+	}
+}
+
+func (gr *graphqlResolver) CreatePost(ctx context.Context, args createPostArgs) (*postResolver, error) {
+	req := requestFrom(ctx)
+	state, err := req.requireAuth()
+	if err != nil {
+		return nil, err
+	}
+	userTextPost := &models.UserTextPost{
+		Author:    args.Input.Author,
+		Title:     args.Input.Title,
+		Body:      args.Input.Body,
+		DraftMode: args.Input.Draftmode,
+	}
+	if args.Input.Publish != nil {
+		userTextPost.Publish = args.Input.Publish.Time
+	}
+	if args.Input.Tags != nil {
+		userTextPost.Tags = *args.Input.Tags
+	}
+	newTextPost, err := gr.ctx.createPost(req.r, state.Principal(), userTextPost)
+	if err != nil {
+		return nil, graphqlStoreError(ctx, err, "error inserting new post into store")
+	}
+	req.forget()
+	return &postResolver{ctx: gr.ctx, short: newTextPost.Short(), full: newTextPost}, nil
+}
+
+type updatePostArgs struct {
+	ID    graphql.ID
+	Input struct {
+		Title     *string
+		Body      *string
+		Publish   *graphql.Time
+		Draftmode *bool
+		Tags      *[]string
+	}
+}
+
+func (gr *graphqlResolver) UpdatePost(ctx context.Context, args updatePostArgs) (*postResolver, error) {
+	req := requestFrom(ctx)
+	state, err := req.requireAuth()
+	if err != nil {
+		return nil, err
+	}
+	postID, err := graphqlPostID(args.ID)
+	if err != nil {
+		return nil, err
+	}
+	post, err := gr.ctx.PostStore.GetTextPostByID(ctx, postID)
+	if err != nil {
+		return nil, graphqlStoreError(ctx, err, "error cannot find post with given ID")
+	}
+	// fields left out keep their values
+	patched := *post
+	if args.Input.Title != nil {
+		patched.Title = *args.Input.Title
+	}
+	if args.Input.Body != nil {
+		patched.Body = *args.Input.Body
+	}
+	if args.Input.Publish != nil {
+		patched.Publish = args.Input.Publish.Time
+	}
+	if args.Input.Draftmode != nil {
+		patched.DraftMode = *args.Input.Draftmode
+	}
+	if args.Input.Tags != nil {
+		patched.Tags = *args.Input.Tags
+	}
+	updatedPost, err := gr.ctx.updatePost(req.r, state.Principal(), post, &patched)
+	if err != nil {
+		return nil, graphqlStoreError(ctx, err, "error updating post")
+	}
+	req.forget()
+	return &postResolver{ctx: gr.ctx, short: updatedPost.Short(), full: updatedPost}, nil
+}
+
+func (gr *graphqlResolver) DeletePost(ctx context.Context, args struct{ ID graphql.ID }) (graphql.ID, error) {
+	req := requestFrom(ctx)
+	state, err := req.requireAuth()
+	if err != nil {
+		return "", err
+	}
+	postID, err := graphqlPostID(args.ID)
+	if err != nil {
+		return "", err
+	}
+	post, err := gr.ctx.PostStore.GetTextPostByID(ctx, postID)
+	if err != nil {
+		return "", graphqlStoreError(ctx, err, "error cannot find post with given ID")
+	}
+	if err := gr.ctx.deletePost(req.r, state.Principal(), post); err != nil {
+		return "", graphqlStoreError(ctx, err, "error handling delete")
+	}
+	req.forget()
+	return args.ID, nil
+}
+
+// postResolver resolves a post. Lists of posts hold them without their
+// bodies, which are only fetched when asked for.
+type postResolver struct {
+	ctx   *ReqCtx
+	short *models.PostShort
+	full  *models.TextPost
+}
+
+func (pr *postResolver) ID() graphql.ID {
+	return graphql.ID(pr.short.ID.Hex())
+}
+
+func (pr *postResolver) Author() *postGroup {
+	return authorGroup(pr.ctx, pr.short.Author)
+}
+
+func (pr *postResolver) Title() string {
+	return pr.short.Title
+}
+
+func (pr *postResolver) Created() graphql.Time {
+	return graphql.Time{Time: pr.short.Created}
+}
+
+func (pr *postResolver) Edited() *graphql.Time {
+	return optionalTime(pr.short.Edited)
+}
+
+func (pr *postResolver) Publish() *graphql.Time {
+	return optionalTime(pr.short.Publish)
+}
+
+func (pr *postResolver) Draftmode() bool {
+	return pr.short.DraftMode
+}
+
+func (pr *postResolver) Body(ctx context.Context) (string, error) {
+	if pr.full == nil {
+		post, err := pr.ctx.PostStore.GetTextPostByID(ctx, pr.short.ID)
+		if err != nil {
+			return "", graphqlStoreError(ctx, err, "error cannot find post with given ID")
+		}
+		pr.full = post
+	}
+	body, _ := pr.ctx.Sanitizer.Sanitize(pr.full.Body)
+	return body, nil
+}
+
+// Sanitized is only set on posts just saved.
+func (pr *postResolver) Sanitized() *[]*removalResolver {
+	if pr.full == nil || len(pr.full.Sanitized) == 0 {
+		return nil
+	}
+	removals := make([]*removalResolver, 0, len(pr.full.Sanitized))
+	for _, removal := range pr.full.Sanitized {
+		removals = append(removals, &removalResolver{removal})
+	}
+	return &removals
+}
+
+func (pr *postResolver) Tags() []*postGroup {
+	tags := make([]*postGroup, 0, len(pr.short.Tags))
+	for _, tag := range pr.short.Tags {
+		tags = append(tags, tagGroup(pr.ctx, tag))
+	}
+	return tags
+}
+
+func (pr *postResolver) Views() int32 {
+	return int32(pr.short.Views)
+}
+
+// removalResolver resolves something taken out of a body.
+type removalResolver struct {
+	removal *sanitize.Removal
+}
+
+func (rr *removalResolver) Element() string {
+	return rr.removal.Element
+}
+
+func (rr *removalResolver) Attribute() *string {
+	if len(rr.removal.Attribute) == 0 {
+		return nil
+	}
+	return &rr.removal.Attribute
+}
+
+func (rr *removalResolver) Count() int32 {
+	return int32(rr.removal.Count)
+}
+
+// optionalTime returns nil for times never set.
+func optionalTime(t time.Time) *graphql.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &graphql.Time{Time: t}
+}
+
+// postGroup resolves a tag or an author, the posts it has are those
+// matches accepts.
+type postGroup struct {
+	ctx     *ReqCtx
+	name    string
+	matches func(*models.PostShort) bool
+}
+
+func tagGroup(ctx *ReqCtx, name string) *postGroup {
+	return &postGroup{ctx: ctx, name: name, matches: func(post *models.PostShort) bool {
+		return hasTag(post, name)
+	}}
+}
+
+func authorGroup(ctx *ReqCtx, name string) *postGroup {
+	return &postGroup{ctx: ctx, name: name, matches: func(post *models.PostShort) bool {
+		return post.Author == name
+	}}
+}
+
+func (pg *postGroup) Name() string {
+	return pg.name
+}
+
+// posts returns the visible posts in the group, newest first.
+func (pg *postGroup) posts(ctx context.Context) ([]*models.PostShort, error) {
+	all, err := requestFrom(ctx).allPosts(ctx, pg.ctx.PostStore)
+	if err != nil || pg.matches == nil {
+		return all, err
+	}
+	var posts []*models.PostShort
+	for _, post := range all {
+		if pg.matches(post) {
+			posts = append(posts, post)
+		}
+	}
+	return posts, nil
+}
+
+func (pg *postGroup) PostCount(ctx context.Context) (int32, error) {
+	posts, err := pg.posts(ctx)
+	return int32(len(posts)), err
+}
+
+// Posts returns a page of the group's posts, the first after the post
+// whose ID is the after cursor.
+func (pg *postGroup) Posts(ctx context.Context, args pageArgs) (*connectionResolver, error) {
+	limit, err := graphqlLimit(args.First, maxPageSize)
+	if err != nil {
+		return nil, err
+	}
+	posts, err := pg.posts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	start := 0
+	if args.After != nil {
+		start = -1
+		for i, post := range posts {
+			if post.ID.Hex() == *args.After {
+				start = i + 1
+				break
+			}
+		}
+		if start < 0 {
+			return nil, &graphqlError{"error after is not a cursor from this list", apierrors.CodeInvalidRequest}
+		}
+	}
+	end := min(start+limit, len(posts))
+	connection := &connectionResolver{
+		totalCount:  int32(len(posts)),
+		hasNextPage: end < len(posts),
+	}
+	for _, post := range posts[start:end] {
+		connection.nodes = append(connection.nodes, &postResolver{ctx: pg.ctx, short: post})
+	}
+	if end > start {
+		cursor := posts[end-1].ID.Hex()
+		connection.endCursor = &cursor
+	}
+	return connection, nil
+}
+
+// connectionResolver resolves a page of posts.
+type connectionResolver struct {
+	nodes       []*postResolver
+	totalCount  int32
+	endCursor   *string
+	hasNextPage bool
+}
+
+func (cr *connectionResolver) Nodes() []*postResolver {
+	if cr.nodes == nil {
+		return []*postResolver{}
+	}
+	return cr.nodes
+}
+
+func (cr *connectionResolver) TotalCount() int32 {
+	return cr.totalCount
+}
+
+func (cr *connectionResolver) PageInfo() *connectionResolver {
+	return cr
+}
+
+func (cr *connectionResolver) EndCursor() *string {
+	return cr.endCursor
+}
+
+func (cr *connectionResolver) HasNextPage() bool {
+	return cr.hasNextPage
+}
+
+func hasTag(post *models.PostShort, tag string) bool {
+	for _, postTag := range post.Tags {
+		if postTag == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Readiness states reported by /readyz.
+const (
+	StateStarting = "starting"
+	StateReady    = "ready"
+	StateDraining = "draining"
+)
+
+// HealthCheck checks that a dependency is usable.
+type HealthCheck func(ctx context.Context) error
+
+// dependencyStatus is what /readyz reports for each dependency.
+type dependencyStatus struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Health answers the orchestrator's liveness and readiness probes.
+// It reports starting until SetState marks it ready.
+type Health struct {
+	// Timeout bounds how long each check may take
+	Timeout time.Duration
+
+	state  atomic.Value
+	lock   sync.RWMutex
+	checks map[string]HealthCheck
+}
+
+// NewHealth returns a Health in the starting state.
+func NewHealth(timeout time.Duration) *Health {
+	h := &Health{
+		Timeout: timeout,
+		checks:  make(map[string]HealthCheck),
+	}
+	h.state.Store(StateStarting)
+	return h
+}
+
+// AddCheck makes readiness depend on check, reported under name.
+func (h *Health) AddCheck(name string, check HealthCheck) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.checks[name] = check
+}
+
+// SetState moves to StateStarting, StateReady or StateDraining.
+func (h *Health) SetState(state string) {
+	h.state.Store(state)
+}
+
+// State returns the current readiness state.
+func (h *Health) State() string {
+	return h.state.Load().(string)
+}
+
+// LiveHandler answers /healthz, it only says the process is up.
+func (h *Health) LiveHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// ReadyHandler answers /readyz, running every check and failing with
+// 503 if one of them fails or we are not in the ready state.
+func (h *Health) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	state := h.State()
+	results := h.runChecks(r.Context())
+	status := http.StatusOK
+	if state != StateReady {
+		status = http.StatusServiceUnavailable
+	}
+	for _, result := range results {
+		if result.Status != "ok" {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Status       string                       `json:"status"`
+		Dependencies map[string]*dependencyStatus `json:"dependencies"`
+	}{
+		Status:       state,
+		Dependencies: results,
+	})
+}
+
+// runChecks runs every check at once and collects their results.
+func (h *Health) runChecks(ctx context.Context) map[string]*dependencyStatus {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	ctx, cancel := context.WithTimeout(ctx, h.Timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var resultsLock sync.Mutex
+	results := make(map[string]*dependencyStatus, len(h.checks))
+	for name, check := range h.checks {
+		wg.Add(1)
+		go func(name string, check HealthCheck) {
+			defer wg.Done()
+			start := time.Now()
+			err := check(ctx)
+			result := &dependencyStatus{
+				Status:    "ok",
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Status = "failing"
+				result.Error = err.Error()
+			}
+			resultsLock.Lock()
+			results[name] = result
+			resultsLock.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+	return results
+}
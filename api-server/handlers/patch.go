@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/KyleWS/blog-api/api-server/apierrors"
+	"github.com/KyleWS/blog-api/api-server/models"
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+// Media types of the patches posts accept, plain JSON is read as a
+// merge patch.
+const (
+	MediaMergePatch = "application/merge-patch+json"
+	MediaJSONPatch  = "application/json-patch+json"
+)
+
+// acceptPatch is sent in the Accept-Patch header, RFC 5789
+var acceptPatch = strings.Join([]string{MediaMergePatch, MediaJSONPatch}, ", ")
+
+// JSONPatchOperation is one operation of a JSON Patch, RFC 6902.
+type JSONPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// patchPost applies the JSON Patch or merge patch, RFC 7396, in the
+// request body to post, returning the patched copy. It writes the
+// error response and returns false when the patch cannot be applied.
+func (ctx *ReqCtx) patchPost(w http.ResponseWriter, r *http.Request, post *models.TextPost) (*models.TextPost, bool) {
+	w.Header().Set("Accept-Patch", acceptPatch)
+	mediaType := "application/json"
+	if contentType := r.Header.Get("Content-Type"); len(contentType) > 0 {
+		parsed, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			apierrors.Write(w, r, http.StatusUnsupportedMediaType, apierrors.CodeUnsupportedMedia, fmt.Sprintf("error parsing content type: %v", err), nil)
+			return nil, false
+		}
+		mediaType = parsed
+	}
+	patch, ok := ctx.readBody(w, r)
+	if !ok {
+		return nil, false
+	}
+	original, err := json.Marshal(post)
+	if err != nil {
+		apierrors.FromStore(w, r, err, "error encoding post")
+		return nil, false
+	}
+
+	var patched []byte
+	switch mediaType {
+	case MediaMergePatch, "application/json":
+		patched, err = jsonpatch.MergePatch(original, patch)
+	case MediaJSONPatch:
+		var operations jsonpatch.Patch
+		operations, err = jsonpatch.DecodePatch(patch)
+		if err == nil {
+			patched, err = operations.Apply(original)
+		}
+	default:
+		apierrors.Write(w, r, http.StatusUnsupportedMediaType, apierrors.CodeUnsupportedMedia, "error patches must be "+acceptPatch, nil)
+		return nil, false
+	}
+	if errors.Is(err, jsonpatch.ErrTestFailed) {
+		apierrors.Write(w, r, http.StatusConflict, apierrors.CodeConflict, fmt.Sprintf("error applying patch: %v", err), nil)
+		return nil, false
+	}
+	if err != nil {
+		apierrors.Write(w, r, http.StatusBadRequest, apierrors.CodeInvalidRequest, fmt.Sprintf("error applying patch: %v", err), nil)
+		return nil, false
+	}
+
+	result, err := post.Patched(patched)
+	if err != nil {
+		apierrors.FromStore(w, r, err, "error applying patch")
+		return nil, false
+	}
+	return result, true
+}
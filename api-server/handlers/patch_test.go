@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/KyleWS/blog-api/api-server/models"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestPatchPost(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	post := &models.TextPost{
+		ID:        bson.ObjectIdHex("5f1d7a0c9b1e8a3d2c4b6a10"),
+		Author:    "github:octocat",
+		Title:     "Hello",
+		Created:   created,
+		Edited:    created,
+		Publish:   created,
+		DraftMode: true,
+		Body:      "first",
+		Tags:      []string{"go"},
+	}
+	cases := []struct {
+		name        string
+		contentType string
+		patch       string
+		status      int
+		// check is called with the patched post when status is 200
+		check func(*models.TextPost) bool
+	}{
+		{
+			name:   "plain JSON is a merge patch",
+			patch:  `{"title": "Hi"}`,
+			status: http.StatusOK,
+			check:  func(p *models.TextPost) bool { return p.Title == "Hi" && p.Body == "first" },
+		},
+		{
+			name:        "merge patch clears with null",
+			contentType: MediaMergePatch,
+			patch:       `{"body": null, "tags": null}`,
+			status:      http.StatusOK,
+			check:       func(p *models.TextPost) bool { return p.Body == "" && p.Tags != nil && len(p.Tags) == 0 },
+		},
+		{
+			name:        "JSON Patch",
+			contentType: MediaJSONPatch + "; charset=utf-8",
+			patch:       `[{"op": "test", "path": "/title", "value": "Hello"}, {"op": "add", "path": "/tags/-", "value": "web"}, {"op": "replace", "path": "/draftmode", "value": false}]`,
+			status:      http.StatusOK,
+			check: func(p *models.TextPost) bool {
+				return !p.DraftMode && len(p.Tags) == 2 && p.Tags[1] == "web"
+			},
+		},
+		{
+			name:        "JSON Patch test failing",
+			contentType: MediaJSONPatch,
+			patch:       `[{"op": "test", "path": "/title", "value": "Stale"}, {"op": "replace", "path": "/title", "value": "Hi"}]`,
+			status:      http.StatusConflict,
+		},
+		{
+			name:        "JSON Patch path missing",
+			contentType: MediaJSONPatch,
+			patch:       `[{"op": "replace", "path": "/nothing/here", "value": 1}]`,
+			status:      http.StatusBadRequest,
+		},
+		{
+			name:        "JSON Patch not a list",
+			contentType: MediaJSONPatch,
+			patch:       `{"title": "Hi"}`,
+			status:      http.StatusBadRequest,
+		},
+		{
+			name:        "immutable field",
+			contentType: MediaMergePatch,
+			patch:       `{"author": "github:mallory"}`,
+			status:      http.StatusBadRequest,
+		},
+		{
+			name:        "required field removed",
+			contentType: MediaJSONPatch,
+			patch:       `[{"op": "remove", "path": "/title"}]`,
+			status:      http.StatusBadRequest,
+		},
+		{
+			name:        "unsupported media type",
+			contentType: "text/plain",
+			patch:       `title=Hi`,
+			status:      http.StatusUnsupportedMediaType,
+		},
+	}
+	ctx := &ReqCtx{MaxRequestBytes: 1 << 20}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPatch, "/v1/posts/"+post.ID.Hex(), strings.NewReader(c.patch))
+			if len(c.contentType) > 0 {
+				r.Header.Set("Content-Type", c.contentType)
+			}
+			w := httptest.NewRecorder()
+			patched, ok := ctx.patchPost(w, r, post)
+			if w.Header().Get("Accept-Patch") != acceptPatch {
+				t.Errorf("got Accept-Patch %q, want %q", w.Header().Get("Accept-Patch"), acceptPatch)
+			}
+			if c.status != http.StatusOK {
+				if ok || w.Code != c.status {
+					t.Fatalf("got status %d and ok %v, want %d: %s", w.Code, ok, c.status, w.Body)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("got status %d, want the patch applied: %s", w.Code, w.Body)
+			}
+			if !c.check(patched) {
+				t.Errorf("got %+v", patched)
+			}
+			if post.Title != "Hello" || post.Body != "first" || len(post.Tags) != 1 {
+				t.Errorf("original post changed: %+v", post)
+			}
+		})
+	}
+}
@@ -4,168 +4,192 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
 
+	"github.com/KyleWS/blog-api/api-server/apierrors"
 	"github.com/KyleWS/blog-api/api-server/logging"
 	"github.com/KyleWS/blog-api/api-server/models"
-	"github.com/KyleWS/blog-api/api-server/sessions"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/mgo.v2/bson"
 )
 
-func (ctx *ReqCtx) PostHandler(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		// no authentication required
-		// handle getting specific post with provided id
-		///// should probably make method for this
-		fullPath := r.URL.RequestURI()
-		splitPath := strings.Split(fullPath, "/")
-		path := splitPath[len(splitPath)-1]
-		if path == "" {
-			http.Error(w, fmt.Sprintf("error cannot fetch empty path"), http.StatusBadRequest)
-			return
-		}
-		if !bson.IsObjectIdHex(path) {
-			http.Error(w, fmt.Sprintf("error path is not valid id"), http.StatusBadRequest)
-			return
-		}
-		bsonID := bson.ObjectIdHex(path)
-		post, err := ctx.PostStore.GetTextPostByID(bsonID)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("error cannot find post with given ID: %v", err), http.StatusBadRequest)
-			return
-		}
-		////// fetch post from db /////////
-		logging.RequestLogger(w, r).WithFields(logrus.Fields{
-			"object_id": bsonID.Hex(),
-			"path":      path,
-			"post":      post,
-		}).Debug("handling /post/ get")
-		json.NewEncoder(w).Encode(post)
-	case http.MethodPost:
-		// require authenticated user
-		if err := sessions.CheckAuthToken(r, ctx.SessionStore); err != nil {
-			http.Error(w, fmt.Sprintf("error access token required: %v", err), http.StatusUnauthorized)
-			return
-		}
-		decodedUserTextPost := &models.UserTextPost{}
-		if err := json.NewDecoder(r.Body).Decode(decodedUserTextPost); err != nil {
-			http.Error(w, fmt.Sprintf("error decoding received json: %v", err), http.StatusBadRequest)
-			return
-		}
-		newTextPost := decodedUserTextPost.GenPostMetaData()
-		if err := ctx.PostStore.InsertTextPost(newTextPost); err != nil {
-			http.Error(w, fmt.Sprintf("error inserting new post into store: %v", err), http.StatusInternalServerError)
-			return
-		}
-		w.WriteHeader(http.StatusCreated)
-		logging.RequestLogger(w, r).WithFields(logrus.Fields{
-			"post": newTextPost,
-		}).Debug("handling /post/ post")
-		json.NewEncoder(w).Encode(newTextPost)
-	case http.MethodPatch:
-		// require authenticated user
-		if err := sessions.CheckAuthToken(r, ctx.SessionStore); err != nil {
-			http.Error(w, fmt.Sprintf("error access token required: %v", err), http.StatusUnauthorized)
-			return
-		}
-		// check that post they want to update exists
-		///// should probably make method for this
-		fullPath := r.URL.RequestURI()
-		splitPath := strings.Split(fullPath, "/")
-		path := splitPath[len(splitPath)-1]
-		if path == "" {
-			http.Error(w, fmt.Sprintf("error cannot fetch empty path"), http.StatusBadRequest)
-			return
-		}
-		if !bson.IsObjectIdHex(path) {
-			http.Error(w, fmt.Sprintf("error path is not valid id"), http.StatusBadRequest)
-			return
-		}
-		bsonID := bson.ObjectIdHex(path)
-		_, err := ctx.PostStore.GetTextPostByID(bsonID)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("error cannot find post with given ID: %v", err), http.StatusBadRequest)
-			return
-		}
-		////// fetch post from db /////////
-		// handle updating a post
-		updates := &models.TextPostUpdates{}
-		if err := json.NewDecoder(r.Body).Decode(updates); err != nil {
-			http.Error(w, fmt.Sprintf("error decoding received json: %v", err), http.StatusBadRequest)
-			return
-		}
-		updatedPost, err := ctx.PostStore.UpdateTextPost(bsonID, updates)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("error updating post: %v", err), http.StatusInternalServerError)
-			return
-		}
-		logrus.WithFields(logrus.Fields{
-			"updated_post": updatedPost,
-			"updates":      updates,
-		}).Debug("handling /post/ patch")
-		json.NewEncoder(w).Encode(updatedPost)
-	case http.MethodDelete:
-		// require authenticated user
-		// require authenticated user
-		if err := sessions.CheckAuthToken(r, ctx.SessionStore); err != nil {
-			http.Error(w, fmt.Sprintf("error access token required: %v", err), http.StatusUnauthorized)
-			return
-		}
-		// check that post they want to update exists
-		///// should probably make method for this
-		fullPath := r.URL.RequestURI()
-		splitPath := strings.Split(fullPath, "/")
-		path := splitPath[len(splitPath)-1]
-		if path == "" {
-			http.Error(w, fmt.Sprintf("error cannot fetch empty path"), http.StatusBadRequest)
-			return
-		}
-		if !bson.IsObjectIdHex(path) {
-			http.Error(w, fmt.Sprintf("error path is not valid id"), http.StatusBadRequest)
-			return
-		}
-		bsonID := bson.ObjectIdHex(path)
-		post, err := ctx.PostStore.GetTextPostByID(bsonID)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("error cannot find post with given ID: %v", err), http.StatusBadRequest)
-			return
-		}
-		////// fetch post from db /////////
-		// handle deleting a specific post
-		// this should be locked down
-		// because deleting everything is bad.
-		if err := ctx.PostStore.DeletePost(bsonID); err != nil {
-			http.Error(w, fmt.Sprintf("error handling delete: %v", err), http.StatusInternalServerError)
-			return
-		}
-		logrus.WithFields(logrus.Fields{
-			"post": post,
-		}).Warn("handling /post/ delete")
-	default:
-		http.Error(w, fmt.Sprintf("only accepts GET, POST, PATCH and DELETE"), http.StatusMethodNotAllowed)
+// postIDFromPath returns the post ID in the {id} path parameter,
+// writing an error response if it is not a valid ID.
+func postIDFromPath(w http.ResponseWriter, r *http.Request) (bson.ObjectId, bool) {
+	path := r.PathValue("id")
+	if path == "" {
+		apierrors.Write(w, r, http.StatusBadRequest, apierrors.CodeInvalidRequest, "error cannot fetch empty path", nil)
+		return "", false
 	}
+	if !bson.IsObjectIdHex(path) {
+		apierrors.Write(w, r, http.StatusBadRequest, apierrors.CodeInvalidRequest, "error path is not valid id", nil)
+		return "", false
+	}
+	return bson.ObjectIdHex(path), true
+}
+
+// GetPostHandler returns the post with the given ID.
+func (ctx *ReqCtx) GetPostHandler(w http.ResponseWriter, r *http.Request) {
+	// no authentication required
+	bsonID, ok := postIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	post, err := ctx.PostStore.GetTextPostByID(r.Context(), bsonID)
+	if err != nil {
+		apierrors.FromStore(w, r, err, "error cannot find post with given ID")
+		return
+	}
+	// posts saved before bodies were sanitized are cleaned on the way out
+	post.Body, _ = ctx.Sanitizer.Sanitize(post.Body)
+	w.Header().Set("Accept-Patch", acceptPatch)
+	logging.RequestLogger(w, r).WithFields(logrus.Fields{
+		"object_id": bsonID.Hex(),
+		"post":      post,
+	}).Debug("handling get post")
+	json.NewEncoder(w).Encode(post)
+}
+
+// CreatePostHandler stores a new post.
+func (ctx *ReqCtx) CreatePostHandler(w http.ResponseWriter, r *http.Request) {
+	// require authenticated user
+	state, err := ctx.Auth.CheckAuthToken(w, r)
+	if err != nil {
+		apierrors.Write(w, r, http.StatusUnauthorized, apierrors.CodeUnauthorized, fmt.Sprintf("error access token required: %v", err), nil)
+		return
+	}
+	decodedUserTextPost := &models.UserTextPost{}
+	if !ctx.decodeJSON(w, r, decodedUserTextPost) {
+		return
+	}
+	newTextPost, err := ctx.createPost(r, state.Principal(), decodedUserTextPost)
+	if err != nil {
+		apierrors.FromStore(w, r, err, "error inserting new post into store")
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	logging.RequestLogger(w, r).WithFields(logrus.Fields{
+		"post": newTextPost,
+	}).Debug("handling create post")
+	json.NewEncoder(w).Encode(newTextPost)
+}
+
+// UpdatePostHandler applies the JSON Patch or merge patch in the
+// request body to the post with the given ID.
+func (ctx *ReqCtx) UpdatePostHandler(w http.ResponseWriter, r *http.Request) {
+	// require authenticated user
+	state, err := ctx.Auth.CheckAuthToken(w, r)
+	if err != nil {
+		apierrors.Write(w, r, http.StatusUnauthorized, apierrors.CodeUnauthorized, fmt.Sprintf("error access token required: %v", err), nil)
+		return
+	}
+	// check that post they want to update exists
+	bsonID, ok := postIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	post, err := ctx.PostStore.GetTextPostByID(r.Context(), bsonID)
+	if err != nil {
+		apierrors.FromStore(w, r, err, "error cannot find post with given ID")
+		return
+	}
+	patched, ok := ctx.patchPost(w, r, post)
+	if !ok {
+		return
+	}
+	updatedPost, err := ctx.updatePost(r, state.Principal(), post, patched)
+	if err != nil {
+		apierrors.FromStore(w, r, err, "error updating post")
+		return
+	}
+	logging.RequestLogger(w, r).WithFields(logrus.Fields{
+		"updated_post": updatedPost,
+	}).Debug("handling update post")
+	json.NewEncoder(w).Encode(updatedPost)
+}
+
+// DeletePostHandler deletes the post with the given ID.
+func (ctx *ReqCtx) DeletePostHandler(w http.ResponseWriter, r *http.Request) {
+	// require authenticated user
+	state, err := ctx.Auth.CheckAuthToken(w, r)
+	if err != nil {
+		apierrors.Write(w, r, http.StatusUnauthorized, apierrors.CodeUnauthorized, fmt.Sprintf("error access token required: %v", err), nil)
+		return
+	}
+	// check that post they want to delete exists
+	bsonID, ok := postIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	post, err := ctx.PostStore.GetTextPostByID(r.Context(), bsonID)
+	if err != nil {
+		apierrors.FromStore(w, r, err, "error cannot find post with given ID")
+		return
+	}
+	// this should be locked down
+	// because deleting everything is bad.
+	if err := ctx.deletePost(r, state.Principal(), post); err != nil {
+		apierrors.FromStore(w, r, err, "error handling delete")
+		return
+	}
+	logging.RequestLogger(w, r).WithFields(logrus.Fields{
+		"post": post,
+	}).Warn("handling delete post")
+}
+
+// RestorePostHandler puts back the post with the given ID as it was
+// when it was last deleted.
+func (ctx *ReqCtx) RestorePostHandler(w http.ResponseWriter, r *http.Request) {
+	// require authenticated user
+	state, err := ctx.Auth.CheckAuthToken(w, r)
+	if err != nil {
+		apierrors.Write(w, r, http.StatusUnauthorized, apierrors.CodeUnauthorized, fmt.Sprintf("error access token required: %v", err), nil)
+		return
+	}
+	bsonID, ok := postIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	deletions, err := ctx.Audit.FindAudit(r.Context(), &models.AuditFilter{
+		Action: models.AuditPostDelete,
+		PostID: bsonID,
+		Limit:  1,
+	})
+	if err != nil {
+		apierrors.FromStore(w, r, err, "error finding deleted post")
+		return
+	}
+	if len(deletions) == 0 || deletions[0].Snapshot == nil {
+		apierrors.Write(w, r, http.StatusNotFound, apierrors.CodeNotFound, "error no deleted post with given ID", nil)
+		return
+	}
+	post := deletions[0].Snapshot
+	// fails with a conflict if the post is already back
+	if err := ctx.PostStore.InsertTextPost(r.Context(), post); err != nil {
+		apierrors.FromStore(w, r, err, "error restoring post")
+		return
+	}
+	event := models.NewAuditEvent(r.Context(), state.Principal(), models.AuditPostRestore)
+	event.PostID = bsonID
+	event.Details = map[string]interface{}{"deletion": deletions[0].ID}
+	ctx.audit(r, event)
+	// to subscribers the post is new again
+	ctx.publish(r, models.WebhookPostCreated, state.Principal(), nil, post)
+	logging.RequestLogger(w, r).WithFields(logrus.Fields{
+		"post": post,
+	}).Warn("handling restore post")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(post)
 }
 
-func (ctx *ReqCtx) AllPostsHandler(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		var allPostsShort []*models.PostShort
-		var err error
-		if err := sessions.CheckAuthToken(r, ctx.SessionStore); err != nil {
-			// get all non-drafts
-			allPostsShort, err = ctx.PostStore.FetchAllShort(false)
-		} else {
-			//get all posts including drafts
-			allPostsShort, err = ctx.PostStore.FetchAllShort(true)
-		}
-		if err != nil {
-			http.Error(w, fmt.Sprintf("error fetching all posts: %v", err), http.StatusInternalServerError)
-			return
-		}
-		json.NewEncoder(w).Encode(allPostsShort)
-	default:
-		http.Error(w, fmt.Sprintf("only accepts GET"), http.StatusMethodNotAllowed)
+// ListPostsHandler returns every post without its body. Drafts are
+// only included for authenticated users.
+func (ctx *ReqCtx) ListPostsHandler(w http.ResponseWriter, r *http.Request) {
+	// only authenticated users get drafts
+	_, authErr := ctx.Auth.CheckAuthToken(w, r)
+	allPostsShort, err := ctx.PostStore.FetchAllShort(r.Context(), authErr == nil)
+	if err != nil {
+		apierrors.FromStore(w, r, err, "error fetching all posts")
+		return
 	}
+	json.NewEncoder(w).Encode(allPostsShort)
 }
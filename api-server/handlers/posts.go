@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/KyleWS/blog-api/api-server/models"
+	"github.com/KyleWS/blog-api/api-server/sanitize"
+)
+
+// createPost validates and sanitizes newPost and stores it for actor,
+// recording it in the audit log and telling webhooks and event streams.
+// Every API creating posts goes through here.
+func (ctx *ReqCtx) createPost(r *http.Request, actor string, newPost *models.UserTextPost) (*models.TextPost, error) {
+	if err := newPost.Validate(&ctx.PostLimits); err != nil {
+		return nil, err
+	}
+	var sanitized []*sanitize.Removal
+	newPost.Body, sanitized = ctx.Sanitizer.Sanitize(newPost.Body)
+	newTextPost := newPost.GenPostMetaData()
+	if err := ctx.PostStore.InsertTextPost(r.Context(), newTextPost); err != nil {
+		return nil, err
+	}
+	event := models.NewAuditEvent(r.Context(), actor, models.AuditPostCreate)
+	event.PostID = newTextPost.ID
+	event.Changes = models.DiffPosts(nil, newTextPost)
+	ctx.audit(r, event)
+	ctx.publish(r, models.WebhookPostCreated, actor, nil, newTextPost)
+	if !newTextPost.DraftMode {
+		ctx.publish(r, models.WebhookPostPublished, actor, nil, newTextPost)
+	}
+	// set once published so only the author sees it
+	newTextPost.Sanitized = sanitized
+	return newTextPost, nil
+}
+
+// updatePost validates and sanitizes patched, post with changes made
+// to it, and stores the fields it changes for actor, returning the
+// result and recording the change like createPost. Nothing is written
+// when nothing changed.
+func (ctx *ReqCtx) updatePost(r *http.Request, actor string, post *models.TextPost, patched *models.TextPost) (*models.TextPost, error) {
+	if err := patched.ValidateChanges(post, &ctx.PostLimits); err != nil {
+		return nil, err
+	}
+	var sanitized []*sanitize.Removal
+	patched.Body, sanitized = ctx.Sanitizer.Sanitize(patched.Body)
+	changes := models.DiffPosts(post, patched)
+	if len(changes) == 0 {
+		return post, nil
+	}
+	updatedPost, err := ctx.PostStore.UpdateTextPost(r.Context(), post.ID, changes, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	ctx.recordUpdate(r, actor, post, updatedPost)
+	updatedPost.Sanitized = sanitized
+	return updatedPost, nil
+}
+
+// recordUpdate records actor changing before into after in the audit
+// log and tells webhooks and event streams.
+func (ctx *ReqCtx) recordUpdate(r *http.Request, actor string, before *models.TextPost, after *models.TextPost) {
+	action := models.AuditPostUpdate
+	if before.DraftMode && !after.DraftMode {
+		action = models.AuditPostPublish
+	}
+	event := models.NewAuditEvent(r.Context(), actor, action)
+	event.PostID = before.ID
+	event.Changes = models.DiffPosts(before, after)
+	ctx.audit(r, event)
+	ctx.publish(r, models.WebhookPostUpdated, actor, before, after)
+	switch {
+	case action == models.AuditPostPublish:
+		ctx.publish(r, models.WebhookPostPublished, actor, before, after)
+	case !before.DraftMode && after.DraftMode:
+		ctx.publish(r, models.WebhookPostUnpublished, actor, before, after)
+	}
+}
+
+// deletePost deletes post for actor, recording it like createPost.
+func (ctx *ReqCtx) deletePost(r *http.Request, actor string, post *models.TextPost) error {
+	if err := ctx.PostStore.DeletePost(r.Context(), post.ID); err != nil {
+		return err
+	}
+	ctx.recordDelete(r, actor, post)
+	return nil
+}
+
+// recordDelete records actor deleting post like recordUpdate.
+func (ctx *ReqCtx) recordDelete(r *http.Request, actor string, post *models.TextPost) {
+	// the snapshot is what RestorePostHandler puts back
+	event := models.NewAuditEvent(r.Context(), actor, models.AuditPostDelete)
+	event.PostID = post.ID
+	event.Snapshot = post
+	ctx.audit(r, event)
+	ctx.publish(r, models.WebhookPostDeleted, actor, post, post)
+}
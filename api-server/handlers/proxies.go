@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/KyleWS/blog-api/api-server/logging"
+)
+
+const headerForwardedFor = "X-Forwarded-For"
+
+// Proxies are the networks of the proxies in front of us, whose
+// forwarding headers we believe, as parsed by
+// config.ParseTrustedProxies.
+type Proxies []*net.IPNet
+
+// Trusted reports whether host is one of the proxies.
+func (p Proxies) Trusted(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// FromProxy reports whether r reached us directly from one of the
+// proxies.
+func (p Proxies) FromProxy(r *http.Request) bool {
+	return p.Trusted(remoteHost(r))
+}
+
+// ClientIP returns the address of the client that made r. When r came
+// through the proxies the client is the last address in
+// X-Forwarded-For that is not one of them.
+func (p Proxies) ClientIP(r *http.Request) string {
+	host := remoteHost(r)
+	if !p.Trusted(host) {
+		return host
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values(headerForwardedFor), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if len(hop) == 0 {
+			continue
+		}
+		if net.ParseIP(hop) == nil {
+			logging.FromContext(r.Context()).WithField(headerForwardedFor, hop).Debug("ignoring malformed forwarded address")
+			break
+		}
+		host = hop
+		if !p.Trusted(hop) {
+			break
+		}
+	}
+	return host
+}
+
+// remoteHost returns the address r came from, without the port.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/KyleWS/blog-api/api-server/apierrors"
+	"github.com/KyleWS/blog-api/api-server/config"
+	"github.com/KyleWS/blog-api/api-server/logging"
+	"github.com/KyleWS/blog-api/api-server/sessions"
+	cache "github.com/patrickmn/go-cache"
+)
+
+// bucket is a token bucket holding up to limit.Requests tokens, one
+// of which is taken by every request.
+type bucket struct {
+	lock    sync.Mutex
+	limit   config.Limit
+	tokens  float64
+	updated time.Time
+}
+
+// take refills the bucket for the time passed since it was last used
+// and takes a token if there is one. It returns whether a token was
+// taken, how many are left and how long until the next one arrives.
+func (b *bucket) take(now time.Time) (bool, int, time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	perToken := b.limit.Per / time.Duration(b.limit.Requests)
+	b.tokens = math.Min(float64(b.limit.Requests), b.tokens+float64(now.Sub(b.updated))/float64(perToken))
+	b.updated = now
+	if b.tokens < 1 {
+		return false, 0, time.Duration((1 - b.tokens) * float64(perToken))
+	}
+	b.tokens--
+	return true, int(b.tokens), time.Duration((float64(b.limit.Requests) - b.tokens) * float64(perToken))
+}
+
+// RateLimiter rejects clients making requests faster than their
+// limit allows. Signed in clients are limited by principal, anyone
+// else by IP address.
+type RateLimiter struct {
+	Handler http.Handler
+	// Routes is consulted to find the route a request is for
+	Routes *http.ServeMux
+	Auth   *sessions.AuthContext
+	// Anonymous and Authenticated are the limits for clients that
+	// are signed out and signed in
+	Anonymous     config.Limit
+	Authenticated config.Limit
+	// RouteLimits override the limits above for the routes with the
+	// given mux patterns, such as "GET /all"
+	RouteLimits map[string]config.Limit
+	// TrustedProxies are the proxies whose X-Forwarded-For header
+	// we believe
+	TrustedProxies Proxies
+
+	buckets *cache.Cache
+}
+
+// NewRateLimiter returns a rate limiter in front of handler, routes is
+// the mux behind handler. Buckets left alone until they are full again
+// are dropped every cleanupInterval.
+func NewRateLimiter(handler http.Handler, routes *http.ServeMux, auth *sessions.AuthContext, anonymous config.Limit, authenticated config.Limit, cleanupInterval time.Duration) *RateLimiter {
+	return &RateLimiter{
+		Handler:       handler,
+		Routes:        routes,
+		Auth:          auth,
+		Anonymous:     anonymous,
+		Authenticated: authenticated,
+		RouteLimits:   map[string]config.Limit{},
+		buckets:       cache.New(cache.NoExpiration, cleanupInterval),
+	}
+}
+
+func (rl *RateLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	limit := rl.Anonymous
+	key := "ip:" + rl.TrustedProxies.ClientIP(r)
+	if principal, ok := rl.Auth.SessionPrincipal(r); ok {
+		limit = rl.Authenticated
+		key = "user:" + principal
+	}
+	if _, pattern := rl.Routes.Handler(r); len(pattern) > 0 {
+		if routeLimit, ok := rl.RouteLimits[pattern]; ok {
+			limit = routeLimit
+			key = pattern + "|" + key
+		}
+	}
+
+	allowed, remaining, wait := rl.bucketFor(key, limit).take(time.Now())
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(wait)))
+	if !allowed {
+		logging.RequestLogger(w, r).WithField("client", key).Warn("rate limit exceeded")
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(wait)))
+		apierrors.Write(w, r, http.StatusTooManyRequests, apierrors.CodeRateLimited,
+			fmt.Sprintf("error too many requests, retry in %d seconds", ceilSeconds(wait)), nil)
+		return
+	}
+	rl.Handler.ServeHTTP(w, r)
+}
+
+// bucketFor returns the bucket for key, creating a full one if there
+// is none. A bucket expires once it would have refilled anyway.
+func (rl *RateLimiter) bucketFor(key string, limit config.Limit) *bucket {
+	if found, ok := rl.buckets.Get(key); ok {
+		b := found.(*bucket)
+		rl.buckets.Set(key, b, limit.Per)
+		return b
+	}
+	b := &bucket{
+		limit:   limit,
+		tokens:  float64(limit.Requests),
+		updated: time.Now(),
+	}
+	if err := rl.buckets.Add(key, b, limit.Per); err != nil {
+		// another request created it first
+		if found, ok := rl.buckets.Get(key); ok {
+			return found.(*bucket)
+		}
+	}
+	return b
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/KyleWS/blog-api/api-server/logging"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// headerXRequestID is the request ID header most proxies set
+const headerXRequestID = "X-Request-ID"
+
+// maxRequestIDLength bounds the request IDs we accept from proxies
+const maxRequestIDLength = 128
+
+// RequestIDs gives every request an ID, carried in its context and
+// sent back in the RequestID header. Requests from trusted proxies
+// keep the ID the proxy gave them in RequestID or X-Request-ID.
+type RequestIDs struct {
+	Handler        http.Handler
+	TrustedProxies Proxies
+}
+
+// NewRequestIDs returns handler with request IDs assigned.
+func NewRequestIDs(handler http.Handler, trustedProxies Proxies) *RequestIDs {
+	return &RequestIDs{
+		Handler:        handler,
+		TrustedProxies: trustedProxies,
+	}
+}
+
+func (ri *RequestIDs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	requestID := ""
+	if ri.TrustedProxies.FromProxy(r) {
+		requestID = r.Header.Get(logging.RequestIDHeader)
+		if len(requestID) == 0 {
+			requestID = r.Header.Get(headerXRequestID)
+		}
+		if !validRequestID(requestID) {
+			requestID = ""
+		}
+	}
+	if len(requestID) == 0 {
+		requestID = bson.NewObjectId().Hex()
+	}
+	w.Header().Set(logging.RequestIDHeader, requestID)
+	ri.Handler.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), requestID)))
+}
+
+// validRequestID reports whether id is short and printable enough to
+// be logged and echoed back as is.
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/KyleWS/blog-api/api-server/apierrors"
+	"github.com/KyleWS/blog-api/api-server/logging"
+	"github.com/KyleWS/blog-api/api-server/models"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// defaultDeliveryLimit is how many deliveries are listed unless
+	// the request asks for fewer or more
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 500
+)
+
+// objectIDFromPath returns the ID in the path parameter name, writing
+// an error response if it is not a valid ID.
+func objectIDFromPath(w http.ResponseWriter, r *http.Request, name string) (bson.ObjectId, bool) {
+	path := r.PathValue(name)
+	if !bson.IsObjectIdHex(path) {
+		apierrors.Write(w, r, http.StatusBadRequest, apierrors.CodeInvalidRequest, fmt.Sprintf("error %s is not valid id", name), nil)
+		return "", false
+	}
+	return bson.ObjectIdHex(path), true
+}
+
+// ListWebhooksHandler lists the webhooks, without their secrets.
+func (ctx *ReqCtx) ListWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := ctx.checkAdmin(w, r); !ok {
+		return
+	}
+	webhooks, err := ctx.WebhookStore.AllWebhooks(r.Context())
+	if err != nil {
+		apierrors.FromStore(w, r, err, "error fetching webhooks")
+		return
+	}
+	for _, webhook := range webhooks {
+		webhook.Secret = ""
+	}
+	json.NewEncoder(w).Encode(webhooks)
+}
+
+// CreateWebhookHandler adds a webhook. The response is the only time
+// its secret is shown.
+func (ctx *ReqCtx) CreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := ctx.checkAdmin(w, r)
+	if !ok {
+		return
+	}
+	decodedNewWebhook := &models.NewWebhook{}
+	if !ctx.decodeJSON(w, r, decodedNewWebhook) {
+		return
+	}
+	newWebhook, err := decodedNewWebhook.ToWebhook(admin.Principal())
+	if err != nil {
+		apierrors.FromStore(w, r, err, "error invalid request")
+		return
+	}
+	if err := ctx.WebhookStore.InsertWebhook(r.Context(), newWebhook); err != nil {
+		apierrors.FromStore(w, r, err, "error inserting new webhook into store")
+		return
+	}
+	logging.RequestLogger(w, r).WithFields(logrus.Fields{
+		"admin":   admin.Principal(),
+		"webhook": newWebhook.ID.Hex(),
+		"url":     newWebhook.URL,
+		"events":  newWebhook.Events,
+	}).Warn("handling create webhook")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newWebhook)
+}
+
+// DeleteWebhookHandler removes the webhook with the given ID.
+func (ctx *ReqCtx) DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := ctx.checkAdmin(w, r)
+	if !ok {
+		return
+	}
+	webhookID, ok := objectIDFromPath(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := ctx.WebhookStore.DeleteWebhook(r.Context(), webhookID); err != nil {
+		apierrors.FromStore(w, r, err, "error deleting webhook")
+		return
+	}
+	logging.RequestLogger(w, r).WithFields(logrus.Fields{
+		"admin":   admin.Principal(),
+		"webhook": webhookID.Hex(),
+	}).Warn("handling delete webhook")
+}
+
+// ListDeliveriesHandler lists the newest deliveries to the webhook
+// with the given ID, with the outcome of every attempt. The limit
+// query parameter caps how many are returned.
+func (ctx *ReqCtx) ListDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := ctx.checkAdmin(w, r); !ok {
+		return
+	}
+	webhookID, ok := objectIDFromPath(w, r, "id")
+	if !ok {
+		return
+	}
+	limit := defaultDeliveryLimit
+	if value := r.URL.Query().Get("limit"); len(value) > 0 {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > maxDeliveryLimit {
+			apierrors.Write(w, r, http.StatusBadRequest, apierrors.CodeInvalidRequest, fmt.Sprintf("error limit must be between 1 and %d", maxDeliveryLimit), nil)
+			return
+		}
+		limit = parsed
+	}
+	deliveries, err := ctx.WebhookStore.DeliveriesFor(r.Context(), webhookID, limit)
+	if err != nil {
+		apierrors.FromStore(w, r, err, "error fetching webhook deliveries")
+		return
+	}
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// RedeliverHandler queues the delivery {delivery} to webhook {id} to
+// be sent again now.
+func (ctx *ReqCtx) RedeliverHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := ctx.checkAdmin(w, r)
+	if !ok {
+		return
+	}
+	webhookID, ok := objectIDFromPath(w, r, "id")
+	if !ok {
+		return
+	}
+	deliveryID, ok := objectIDFromPath(w, r, "delivery")
+	if !ok {
+		return
+	}
+	delivery, err := ctx.WebhookStore.GetDelivery(r.Context(), deliveryID)
+	if err == nil && delivery.WebhookID != webhookID {
+		err = fmt.Errorf("error delivery is for another webhook: %w", models.ErrNotFound)
+	}
+	if err != nil {
+		apierrors.FromStore(w, r, err, "error cannot find delivery")
+		return
+	}
+	if err := ctx.Webhooks.Redeliver(r.Context(), deliveryID); err != nil {
+		apierrors.FromStore(w, r, err, "error queueing redelivery")
+		return
+	}
+	logging.RequestLogger(w, r).WithFields(logrus.Fields{
+		"admin":    admin.Principal(),
+		"webhook":  webhookID.Hex(),
+		"delivery": deliveryID.Hex(),
+	}).Info("handling redeliver webhook")
+	w.WriteHeader(http.StatusAccepted)
+}
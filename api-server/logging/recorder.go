@@ -0,0 +1,61 @@
+package logging
+
+import "net/http"
+
+// ResponseRecorder remembers the status code and body size of the
+// response written through it.
+type ResponseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// NewResponseRecorder returns a recorder writing through to w.
+func NewResponseRecorder(w http.ResponseWriter) *ResponseRecorder {
+	return &ResponseRecorder{
+		ResponseWriter: w,
+	}
+}
+
+// Status returns the status code written, which is 200 when the
+// handler did not set one.
+func (rr *ResponseRecorder) Status() int {
+	if rr.status == 0 {
+		return http.StatusOK
+	}
+	return rr.status
+}
+
+// Bytes returns the number of body bytes written.
+func (rr *ResponseRecorder) Bytes() int64 {
+	return rr.bytes
+}
+
+func (rr *ResponseRecorder) WriteHeader(status int) {
+	if rr.status == 0 {
+		rr.status = status
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *ResponseRecorder) Write(body []byte) (int, error) {
+	if rr.status == 0 {
+		// net/http sends 200 for bodies written without a status
+		rr.status = http.StatusOK
+	}
+	n, err := rr.ResponseWriter.Write(body)
+	rr.bytes += int64(n)
+	return n, err
+}
+
+// Flush passes flushes through for streaming responses.
+func (rr *ResponseRecorder) Flush() {
+	if flusher, ok := rr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rr *ResponseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
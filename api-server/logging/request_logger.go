@@ -1,20 +1,51 @@
 package logging
 
 import (
+	"context"
 	"net/http"
 
 	logrus "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 )
 
-const headerAuthorization = "Authorization"
-const requestIdHeader = "RequestID"
+// RequestIDHeader carries the ID of a request, we accept it from
+// trusted proxies and always send it back.
+const RequestIDHeader = "RequestID"
 
+type contextKey int
+
+const requestIDKey contextKey = iota
+
+// WithRequestID returns ctx carrying the request ID id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request ID carried by ctx, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// FromContext returns a logger tagging lines with the request ID and
+// trace ID carried by ctx, so they can be matched up with the request
+// they were logged for.
+func FromContext(ctx context.Context) *logrus.Entry {
+	entry := logrus.NewEntry(logrus.StandardLogger())
+	if id := RequestID(ctx); len(id) > 0 {
+		entry = entry.WithField("RequestID", id)
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		entry = entry.WithField("trace_id", spanContext.TraceID().String())
+	}
+	return entry
+}
+
+// RequestLogger returns a logger for lines about handling r.
 func RequestLogger(w http.ResponseWriter, r *http.Request) *logrus.Entry {
-	return logrus.WithFields(logrus.Fields{
-		"method":      r.Method,
-		"uri":         r.URL.RequestURI(),
-		"auth_header": w.Header().Get(requestIdHeader),
-		"agent":       r.UserAgent(),
-		"RequestID":   w.Header().Get(requestIdHeader),
+	return FromContext(r.Context()).WithFields(logrus.Fields{
+		"method": r.Method,
+		"uri":    r.URL.RequestURI(),
+		"agent":  r.UserAgent(),
 	})
 }
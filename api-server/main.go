@@ -1,14 +1,28 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/KyleWS/blog-api/api-server/apierrors"
+	"github.com/KyleWS/blog-api/api-server/config"
+	"github.com/KyleWS/blog-api/api-server/events"
 	"github.com/KyleWS/blog-api/api-server/handlers"
+	"github.com/KyleWS/blog-api/api-server/metrics"
 	"github.com/KyleWS/blog-api/api-server/models"
+	"github.com/KyleWS/blog-api/api-server/openapi"
+	"github.com/KyleWS/blog-api/api-server/sanitize"
 	"github.com/KyleWS/blog-api/api-server/sessions"
+	"github.com/KyleWS/blog-api/api-server/tracing"
+	"github.com/KyleWS/blog-api/api-server/webhooks"
 	cache "github.com/patrickmn/go-cache"
 	logrus "github.com/sirupsen/logrus"
 	"golang.org/x/oauth2"
@@ -22,71 +36,321 @@ const (
 )
 
 func main() {
-	// Logging options
-	logLevel := os.Getenv("LOG_LEVEL")
 	logrus.SetFormatter(&logrus.JSONFormatter{})
 	logrus.SetOutput(os.Stdout)
-	if logLevel == "DEBUG" {
-		logrus.SetLevel(logrus.DebugLevel)
-	} else if logLevel == "INFO" {
-		logrus.SetLevel(logrus.InfoLevel)
-	} else {
-		logrus.SetLevel(logrus.WarnLevel)
-	}
-
-	// Environment variables
-	addr := os.Getenv("ADDR")
-	gitRedir := os.Getenv("GITREDIR")
-	clientID := os.Getenv("CLIENT_ID")
-	clientSecret := os.Getenv("CLIENT_SECRET")
-	tls_cert := os.Getenv("TLS_CERT")
-	tls_secret := os.Getenv("TLS_SECRET")
-	dbaddr := os.Getenv("DBADDR")
-	dbName := os.Getenv("POSTS_DB_NAME")
-	colName := os.Getenv("POSTS_COLLECTION_NAME")
-
-	sess, err := mgo.Dial(dbaddr)
+
+	cfg, opts, err := config.Load(os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		logrus.WithField("err", err).Fatal("error loading configuration")
+	}
+	validationErr := cfg.Validate()
+	if opts.PrintConfig {
+		cfg.Print(os.Stdout)
+		if validationErr != nil {
+			fmt.Fprintln(os.Stderr, validationErr)
+			os.Exit(1)
+		}
+		return
+	}
+	if validationErr != nil {
+		fmt.Fprintln(os.Stderr, validationErr)
+		os.Exit(2)
+	}
+	level, _ := cfg.Level()
+	logrus.SetLevel(level)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.TraceExporter)
+	if err != nil {
+		logrus.WithField("err", err).Fatal("error setting up tracing")
+	}
+
+	sess, err := mgo.Dial(cfg.DBAddr)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
-			"dbaddr":  dbaddr,
-			"dbName":  dbName,
-			"colName": colName,
+			"dbName":  cfg.DBName,
+			"colName": cfg.PostsCollection,
+			"err":     err,
 		}).Fatal("error connecting to db")
 	}
 
-	postStore := models.NewMongoStore(sess, dbName, colName)
-	sessionStore := sessions.NewMemStore(120*time.Minute, time.Minute)
+	postStore := models.NewMongoStore(sess, cfg.DBName, cfg.PostsCollection)
+	postStore.Observer = metrics.ObserveStore
+	auditStore := models.NewMongoAuditStore(sess, cfg.DBName, cfg.AuditCollection)
+	auditStore.Observer = metrics.ObserveStore
+	webhookStore := models.NewMongoWebhookStore(sess, cfg.DBName, cfg.WebhooksCollection, cfg.DeliveriesCollection)
+	webhookStore.Observer = metrics.ObserveStore
+	dispatcher := webhooks.NewDispatcher(webhookStore, cfg.WebhookTimeout, cfg.WebhookMaxAttempts)
+	userStore := models.NewMongoUserStore(sess, cfg.DBName, cfg.UsersCollection, cfg.RulesCollection)
+	if err := userStore.EnsureIndexes(); err != nil {
+		logrus.WithField("err", err).Fatal("error creating user indexes")
+	}
+	if err := seedUsers(userStore, cfg.Whitelist); err != nil {
+		logrus.WithField("err", err).Fatal("error seeding users from the whitelist")
+	}
+	sessionStore := sessions.NewMemStore(cfg.SessionIdleTimeout, cfg.SessionAbsoluteTimeout, time.Minute)
+	metrics.RegisterSessionCount(sessionStore.Count)
 
-	// Used to authenticate with Github
+	// Used to authenticate with Github and/or an OpenID Connect
+	// provider, whichever are configured
 	// below is so I can run locally and in deployment
-	if len(addr) > 0 {
-		gitRedir = addr
-	}
-	githubCtx := &sessions.GithubContext{
-		OauthConfig: &oauth2.Config{
-			ClientID:     clientID,
-			ClientSecret: clientSecret,
-			Scopes:       []string{"read:user"},
-			RedirectURL:  "https://" + gitRedir + apiReply,
+	redirectHost := cfg.RedirectHost
+	if len(cfg.Addr) > 0 {
+		redirectHost = cfg.Addr
+	}
+	var providers []sessions.Provider
+	if len(cfg.GithubClientID) > 0 {
+		providers = append(providers, sessions.NewGithubProvider(&oauth2.Config{
+			ClientID:     cfg.GithubClientID,
+			ClientSecret: cfg.GithubClientSecret,
+			Scopes:       []string{"read:user", "read:org"},
+			RedirectURL:  "https://" + redirectHost + apiReply,
 			Endpoint:     github.Endpoint,
-		},
-		StateCache:   cache.New(5*time.Minute, 10*time.Second),
-		SessionCache: sessionStore,
+		}))
+	}
+	if len(cfg.OIDCIssuer) > 0 {
+		// an unreachable provider fails startup rather than hanging it
+		discoveryCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		oidcProvider, err := sessions.NewOIDCProvider(discoveryCtx, &sessions.OIDCConfig{
+			Issuer:        cfg.OIDCIssuer,
+			ClientID:      cfg.OIDCClientID,
+			ClientSecret:  cfg.OIDCClientSecret,
+			RedirectURL:   "https://" + redirectHost + apiReply,
+			Scopes:        cfg.OIDCScopes,
+			UsernameClaim: cfg.OIDCUsernameClaim,
+			HTTPClient:    &http.Client{Timeout: 10 * time.Second},
+		})
+		cancel()
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"issuer": cfg.OIDCIssuer,
+				"err":    err,
+			}).Fatal("error configuring oidc provider")
+		}
+		providers = append(providers, oidcProvider)
+	}
+	authCtx := sessions.NewAuthContext(cache.New(5*time.Minute, 10*time.Second), sessionStore, userStore, providers...)
+	authCtx.ReturnOrigins = cfg.FrontendOrigins
+	authCtx.SessionDelivery = cfg.SessionDelivery
+	switch strings.ToLower(cfg.SessionCookieSameSite) {
+	case "lax":
+		authCtx.CookieSameSite = http.SameSiteLaxMode
+	case "strict":
+		authCtx.CookieSameSite = http.SameSiteStrictMode
+	case "none":
+		// needed when the frontend is on another site than the api
+		authCtx.CookieSameSite = http.SameSiteNoneMode
 	}
+	authCtx.AllowQueryToken = !cfg.DisableAuthQuery
+	authCtx.Audit = auditStore
 	// Used to verify every request user makes to API
 	reqCtx := handlers.ReqCtx{
 		PostStore:    postStore,
+		UserStore:    userStore,
 		SessionStore: sessionStore,
+		Auth:         authCtx,
+		Audit:        auditStore,
+		Webhooks:     dispatcher,
+		WebhookStore: webhookStore,
+
+		Events:          events.NewBroker(cfg.EventsBuffer),
+		EventsHeartbeat: cfg.EventsHeartbeat,
+
+		PostLimits: models.PostLimits{
+			MaxTitleLength:  cfg.PostMaxTitleLength,
+			MaxBodyBytes:    cfg.PostMaxBodyBytes,
+			MaxTags:         cfg.PostMaxTags,
+			MaxTagLength:    cfg.PostMaxTagLength,
+			MaxPublishAhead: cfg.PostMaxPublishAhead,
+		},
+		MaxRequestBytes: int64(cfg.RequestMaxBytes),
+		BulkMaxPosts:    cfg.BulkMaxPosts,
+		Sanitizer:       sanitize.New(cfg.HTMLURLSchemes, cfg.HTMLIframeHosts),
 	}
 
+	graphQL, err := handlers.NewGraphQL(&reqCtx, cfg.GraphQLMaxDepth, cfg.GraphQLMaxCost)
+	if err != nil {
+		logrus.WithField("err", err).Fatal("error creating graphql endpoint")
+	}
 	mux := http.NewServeMux()
-	mux.HandleFunc(apiSignIn, githubCtx.OAuthSignInHandler)
-	mux.HandleFunc(apiReply, githubCtx.OAuthReplyHandler)
-	mux.HandleFunc("/post/", reqCtx.PostHandler)
-	mux.HandleFunc("/all", reqCtx.AllPostsHandler)
-	corsMux := handlers.NewCORS(mux)
+	api := openapi.New(mux, "blog-api", "1")
+	api.MaxBody = int64(cfg.RequestMaxBytes)
+	api.Authorized = func(r *http.Request, auth string) bool {
+		role, ok := authCtx.SessionRole(r)
+		return ok && (auth != openapi.AuthAdmin || role == models.RoleAdmin)
+	}
+	registerRoutes(api, &reqCtx, authCtx, graphQL)
+
+	timeouts := serverTimeouts{
+		ReadHeader: cfg.HTTPReadHeaderTimeout,
+		Read:       cfg.HTTPReadTimeout,
+		Write:      cfg.HTTPWriteTimeout,
+		Idle:       cfg.HTTPIdleTimeout,
+	}
+	// metrics get a listener of their own when METRICS_ADDR is set,
+	// otherwise they are only served to scrapers holding METRICS_TOKEN
+	var servers []*http.Server
+	if len(cfg.MetricsAddr) > 0 {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("GET /metrics", metrics.Handler(cfg.MetricsToken))
+		metricsServer := newServer(cfg.MetricsAddr, metricsMux, timeouts)
+		metricsStopped, err := serve(metricsServer, "", "")
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"addr": cfg.MetricsAddr,
+				"err":  err,
+			}).Fatal("error listening for metrics")
+		}
+		go func() {
+			if err := <-metricsStopped; !errors.Is(err, http.ErrServerClosed) {
+				logrus.WithField("err", err).Error("error metrics server stopped")
+			}
+		}()
+		servers = append(servers, metricsServer)
+		logrus.WithField("addr", cfg.MetricsAddr).Info("metrics now listening")
+	} else if len(cfg.MetricsToken) > 0 {
+		api.Handle("GET /metrics", metrics.Handler(cfg.MetricsToken), &openapi.Operation{
+			Summary: "Metrics in the Prometheus text format, scrapers send the metrics token as a bearer token",
+		})
+	}
+
+	corsPolicy := handlers.DefaultCORSPolicy()
+	corsPolicy.AllowedOrigins = cfg.CORSOrigins()
+	corsPolicy.AllowCredentials = cfg.CORSAllowCredentials
+	corsPolicy.MaxAge = cfg.CORSMaxAge
+	// the limits and proxies were checked by cfg.Validate
+	anonymousLimit, _ := config.ParseLimit(cfg.RateLimitAnonymous)
+	authenticatedLimit, _ := config.ParseLimit(cfg.RateLimitAuthenticated)
+	rateLimiter := handlers.NewRateLimiter(apierrors.JSONMux(mux), mux, authCtx, anonymousLimit, authenticatedLimit, time.Minute)
+	proxyNetworks, _ := config.ParseTrustedProxies(cfg.TrustedProxies)
+	trustedProxies := handlers.Proxies(proxyNetworks)
+	rateLimiter.TrustedProxies = trustedProxies
+	rateLimiter.RouteLimits, _ = cfg.RouteLimits()
+	corsMux := handlers.NewCORS(tracing.NewTraced(rateLimiter, mux), mux, corsPolicy)
+	accessLog := handlers.NewAccessLog(corsMux, mux, authCtx, cfg.AccessLogFormat, os.Stdout)
+	accessLog.SampleSuccesses = cfg.AccessLogSampleSuccesses
+	accessLog.TrustedProxies = trustedProxies
+	instrumentedMux := metrics.NewInstrumented(handlers.NewRequestIDs(accessLog, trustedProxies), mux)
 
-	logrus.WithField("addr", addr).Info("blog api server now listening")
-	log.Fatal(http.ListenAndServeTLS(addr, tls_cert, tls_secret, corsMux))
+	// probes skip the middleware so they are never rate limited or
+	// counted as traffic
+	health := handlers.NewHealth(2 * time.Second)
+	health.AddCheck("mongodb", postStore.Ping)
+	health.AddCheck("sessions", sessionStore.Ping)
+	rootMux := http.NewServeMux()
+	rootMux.HandleFunc("GET /healthz", health.LiveHandler)
+	rootMux.HandleFunc("GET /readyz", health.ReadyHandler)
+	api.Describe("GET /healthz", &openapi.Operation{
+		Summary:  "Report the process is up",
+		Response: map[string]string{},
+	})
+	api.Describe("GET /readyz", &openapi.Operation{
+		Summary:  "Report whether the server and its dependencies are ready for traffic",
+		Response: map[string]interface{}{},
+	})
+	rootMux.Handle("/", instrumentedMux)
+
+	// plain HTTP is for running behind a proxy that terminates TLS
+	certFile, keyFile := cfg.TLSCert, cfg.TLSKey
+	if cfg.PlainHTTP {
+		certFile, keyFile = "", ""
+	}
+	server := newServer(cfg.Addr, rootMux, timeouts)
+	// event streams never finish on their own
+	server.RegisterOnShutdown(reqCtx.Events.Close)
+	stopped, err := serve(server, certFile, keyFile)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"addr": cfg.Addr,
+			"err":  err,
+		}).Fatal("error listening")
+	}
+	health.SetState(handlers.StateReady)
+	dispatcher.Start()
+	logrus.WithFields(logrus.Fields{
+		"addr": cfg.Addr,
+		"tls":  len(certFile) > 0,
+	}).Info("blog api server now listening")
+	go reloadOnHangup(cfg, opts)
 
+	servers = append([]*http.Server{server}, servers...)
+	err = waitForShutdown(stopped, health, cfg.ShutdownDrainDelay, cfg.ShutdownTimeout, servers,
+		shutdownStep{"webhooks", dispatcher.Stop},
+		shutdownStep{"tracing", shutdownTracing},
+		shutdownStep{"database", func(context.Context) error {
+			sess.Close()
+			return nil
+		}},
+		shutdownStep{"logs", func(context.Context) error {
+			// fails harmlessly when stdout is a pipe
+			os.Stdout.Sync()
+			return nil
+		}},
+	)
+	if err != nil {
+		logrus.WithField("err", err).Fatal("error server stopped unexpectedly")
+	}
+}
+
+// seedUsers adds the entries of the whitelist to an empty user store,
+// the first as an admin so a fresh deployment has someone who can
+// manage users and the rest as writers. Entries are "provider:login",
+// bare logins are GitHub logins. A store that already has users is
+// left alone, they are managed through the admin API from then on.
+func seedUsers(userStore *models.MongoUserStore, whitelist []string) error {
+	count, err := userStore.CountUsers()
+	if err != nil || count > 0 {
+		return err
+	}
+	for i, entry := range whitelist {
+		seed := &models.NewUser{
+			Provider: "github",
+			Login:    entry,
+			Role:     models.RoleWriter,
+		}
+		if i == 0 {
+			seed.Role = models.RoleAdmin
+		}
+		if sep := strings.Index(entry, ":"); sep >= 0 {
+			seed.Provider, seed.Login = entry[:sep], entry[sep+1:]
+		}
+		user, err := seed.ToUser("BLOGAPI_WHITELIST")
+		if err != nil {
+			return err
+		}
+		if err := userStore.InsertUser(user); err != nil {
+			if errors.Is(err, models.ErrConflict) {
+				continue
+			}
+			return err
+		}
+		logrus.WithField("user", user).Info("seeded user from whitelist")
+	}
+	return nil
+}
+
+// reloadOnHangup reloads the configuration whenever we get SIGHUP.
+// The log level is applied, anything else that changed only takes
+// effect on restart.
+func reloadOnHangup(cfg *config.Config, opts *config.Options) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	for range hangups {
+		next, _, err := config.Load(os.Args[1:])
+		if err == nil {
+			err = next.Validate()
+		}
+		if err != nil {
+			logrus.WithField("err", err).Error("error reloading configuration, keeping the current one")
+			continue
+		}
+		if restart := cfg.Reload(next); len(restart) > 0 {
+			logrus.WithField("settings", restart).Warn("changed settings need a restart to take effect")
+		}
+		level, _ := cfg.Level()
+		logrus.SetLevel(level)
+		logrus.WithField("config", opts.File).Warn("configuration reloaded")
+	}
 }
@@ -0,0 +1,150 @@
+// Package metrics keeps the Prometheus metrics the server exposes on
+// /metrics.
+package metrics
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/KyleWS/blog-api/api-server/apierrors"
+	"github.com/KyleWS/blog-api/api-server/logging"
+	"github.com/KyleWS/blog-api/api-server/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "blogapi"
+
+// Login outcomes counted by Logins.
+const (
+	LoginSuccess = "success"
+	LoginFailure = "failure"
+	LoginDenied  = "denied"
+)
+
+// Registry holds every metric we expose, along with the Go runtime
+// and process metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests served, by route, method and status.",
+	}, []string{"route", "method", "status"})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to serve HTTP requests, by route, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+	storeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "store_operation_duration_seconds",
+		Help:      "Time taken by post store operations, by operation.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation"})
+	storeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "store_errors_total",
+		Help:      "Post store operations that failed, by operation and kind of error.",
+	}, []string{"operation", "kind"})
+	// Logins counts OAuth sign ins by provider and outcome
+	Logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "OAuth sign ins, by provider and outcome.",
+	}, []string{"provider", "outcome"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requests, requestDuration, storeDuration, storeErrors, Logins,
+	)
+}
+
+// RegisterSessionCount exposes the number of active sessions, as
+// reported by count.
+func RegisterSessionCount(count func() int) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_sessions",
+		Help:      "Sessions currently held in the session store.",
+	}, func() float64 {
+		return float64(count())
+	}))
+}
+
+// ObserveStore records a store operation, it is a models.StoreObserver.
+func ObserveStore(operation string, duration time.Duration, err error) {
+	storeDuration.WithLabelValues(operation).Observe(duration.Seconds())
+	if err == nil {
+		return
+	}
+	kind := "other"
+	switch {
+	case errors.Is(err, models.ErrNotFound):
+		kind = "not_found"
+	case errors.Is(err, models.ErrConflict):
+		kind = "conflict"
+	case errors.Is(err, models.ErrInvalid):
+		kind = "invalid"
+	case errors.Is(err, models.ErrUnavailable):
+		kind = "unavailable"
+	}
+	storeErrors.WithLabelValues(operation, kind).Inc()
+}
+
+// Handler serves the metrics in the Prometheus text format. When token
+// is not empty scrapers must send it as a bearer token.
+func Handler(token string) http.Handler {
+	metricsHandler := promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+	if len(token) == 0 {
+		return metricsHandler
+	}
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			apierrors.Write(w, r, http.StatusUnauthorized, apierrors.CodeUnauthorized, "error metrics token required", nil)
+			return
+		}
+		metricsHandler.ServeHTTP(w, r)
+	})
+}
+
+// Instrumented counts and times every request handled by handler.
+// Requests are labeled with the pattern of the route in routes they
+// match, so paths holding IDs do not each get their own series.
+type Instrumented struct {
+	Handler http.Handler
+	Routes  *http.ServeMux
+}
+
+// NewInstrumented returns handler instrumented, routes is the mux
+// behind handler.
+func NewInstrumented(handler http.Handler, routes *http.ServeMux) *Instrumented {
+	return &Instrumented{
+		Handler: handler,
+		Routes:  routes,
+	}
+}
+
+func (in *Instrumented) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	route := "unmatched"
+	if _, pattern := in.Routes.Handler(r); len(pattern) > 0 {
+		route = pattern
+	}
+	recorder := logging.NewResponseRecorder(w)
+	in.Handler.ServeHTTP(recorder, r)
+	status := strconv.Itoa(recorder.Status())
+	requests.WithLabelValues(route, r.Method, status).Inc()
+	requestDuration.WithLabelValues(route, r.Method, status).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/KyleWS/blog-api/api-server/logging"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Actions recorded in the audit log.
+const (
+	AuditPostCreate    = "post.create"
+	AuditPostUpdate    = "post.update"
+	AuditPostPublish   = "post.publish"
+	AuditPostDelete    = "post.delete"
+	AuditPostRestore   = "post.restore"
+	AuditLogin         = "login"
+	AuditLoginDenied   = "login.denied"
+	AuditSessionRevoke = "session.revoke"
+)
+
+// FieldChange is the value of one field before and after a change.
+type FieldChange struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditEvent records who did what, and when.
+type AuditEvent struct {
+	ID     bson.ObjectId `json:"id" bson:"_id"`
+	Time   time.Time     `json:"time"`
+	Actor  string        `json:"actor"`
+	Action string        `json:"action"`
+	// PostID is the post acted on, if any
+	PostID bson.ObjectId `json:"post,omitempty" bson:"post,omitempty"`
+	// Subject is the user acted on as provider:login, such as the
+	// one whose sessions were revoked, or rule:id for an access rule
+	Subject string         `json:"subject,omitempty" bson:"subject,omitempty"`
+	Changes []*FieldChange `json:"changes,omitempty" bson:"changes,omitempty"`
+	// Snapshot is a deleted post as it was, so it can be restored
+	Snapshot  *TextPost              `json:"snapshot,omitempty" bson:"snapshot,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty" bson:"details,omitempty"`
+	RequestID string                 `json:"requestId,omitempty" bson:"requestid,omitempty"`
+}
+
+// AuditFilter selects audit events, empty fields match everything.
+type AuditFilter struct {
+	Actor  string
+	Action string
+	PostID bson.ObjectId
+	Since  time.Time
+	// Limit caps how many of the newest matching events are returned
+	Limit int
+}
+
+// AuditStore keeps the audit log. Events can only be added, never
+// changed or removed.
+type AuditStore interface {
+	AppendAudit(ctx context.Context, event *AuditEvent) error
+
+	// FindAudit returns the events matching filter, newest first
+	FindAudit(ctx context.Context, filter *AuditFilter) ([]*AuditEvent, error)
+}
+
+// NewAuditEvent returns an event for actor doing action, tagged with
+// the request ID carried by ctx.
+func NewAuditEvent(ctx context.Context, actor string, action string) *AuditEvent {
+	return &AuditEvent{
+		ID:        bson.NewObjectId(),
+		Time:      time.Now(),
+		Actor:     actor,
+		Action:    action,
+		RequestID: logging.RequestID(ctx),
+	}
+}
+
+// DiffPosts lists the fields users can set that differ between before
+// and after, either of which may be nil.
+func DiffPosts(before *TextPost, after *TextPost) []*FieldChange {
+	fieldsOf := func(post *TextPost) map[string]interface{} {
+		if post == nil {
+			return map[string]interface{}{}
+		}
+		return map[string]interface{}{
+			"author":    post.Author,
+			"title":     post.Title,
+			"publish":   post.Publish,
+			"draftmode": post.DraftMode,
+			"body":      post.Body,
+			"tags":      post.Tags,
+		}
+	}
+	beforeFields, afterFields := fieldsOf(before), fieldsOf(after)
+	changes := make([]*FieldChange, 0)
+	for _, name := range []string{"author", "title", "publish", "draftmode", "body", "tags"} {
+		if reflect.DeepEqual(beforeFields[name], afterFields[name]) {
+			continue
+		}
+		changes = append(changes, &FieldChange{
+			Field:  name,
+			Before: beforeFields[name],
+			After:  afterFields[name],
+		})
+	}
+	return changes
+}
@@ -0,0 +1,197 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Operations a BulkPostRequest can apply.
+const (
+	BulkPublish    = "publish"
+	BulkUnpublish  = "unpublish"
+	BulkAddTags    = "add_tags"
+	BulkRemoveTags = "remove_tags"
+	BulkSetAuthor  = "set_author"
+	BulkTrash      = "trash"
+)
+
+// PostFilter selects posts, empty fields match everything.
+type PostFilter struct {
+	Tag       string `json:"tag"`
+	Author    string `json:"author"`
+	DraftMode *bool  `json:"draftmode"`
+}
+
+// BulkPostRequest applies one operation to the posts with the given
+// IDs, or to those matching Filter.
+type BulkPostRequest struct {
+	IDs       []string    `json:"ids"`
+	Filter    *PostFilter `json:"filter"`
+	Operation string      `json:"operation"`
+	// Tags are what add_tags and remove_tags add or remove, as written
+	Tags []string `json:"tags"`
+	// Author is who set_author hands the posts to
+	Author string `json:"author"`
+	// AllOrNothing checks every post before writing and leaves them all
+	// as they were if any would fail. The write itself is not atomic,
+	// posts changed since they were checked are left out of it.
+	AllOrNothing bool `json:"all_or_nothing"`
+}
+
+// Validate checks req names at most maxPosts posts and an operation
+// that can be applied within limits.
+func (req *BulkPostRequest) Validate(limits *PostLimits, maxPosts int) error {
+	problems := &ValidationError{}
+	switch {
+	case len(req.IDs) == 0 && req.Filter == nil:
+		problems.Add("ids", "or filter is required")
+	case len(req.IDs) > 0 && req.Filter != nil:
+		problems.Add("filter", "cannot be used with ids")
+	case len(req.IDs) > maxPosts:
+		problems.Add("ids", fmt.Sprintf("must be at most %d posts", maxPosts))
+	}
+	seen := make(map[string]bool, len(req.IDs))
+	for i, id := range req.IDs {
+		field := fmt.Sprintf("ids.%d", i)
+		switch {
+		case !bson.IsObjectIdHex(id):
+			problems.Add(field, "is not a post ID")
+		case seen[id]:
+			problems.Add(field, fmt.Sprintf("duplicates post %s", id))
+		}
+		seen[id] = true
+	}
+	if req.Filter != nil && len(req.Filter.Tag) == 0 && len(req.Filter.Author) == 0 && req.Filter.DraftMode == nil {
+		problems.Add("filter", "must have a tag, author or draftmode")
+	}
+
+	switch req.Operation {
+	case BulkAddTags:
+		if len(req.Tags) == 0 {
+			problems.Add("tags", "is required to add tags")
+		}
+		limits.check(problems, "", "", req.Tags, time.Time{})
+	case BulkRemoveTags:
+		if len(req.Tags) == 0 {
+			problems.Add("tags", "is required to remove tags")
+		}
+	case BulkSetAuthor:
+		if len(strings.TrimSpace(req.Author)) == 0 {
+			problems.Add("author", "is required to set the author")
+		}
+	case BulkPublish, BulkUnpublish, BulkTrash:
+	default:
+		problems.Add("operation", "must be publish, unpublish, add_tags, remove_tags, set_author or trash")
+	}
+	if len(req.Tags) > 0 && req.Operation != BulkAddTags && req.Operation != BulkRemoveTags {
+		problems.Add("tags", "is only used to add or remove tags")
+	}
+	if len(req.Author) > 0 && req.Operation != BulkSetAuthor {
+		problems.Add("author", "is only used to set the author")
+	}
+	return problems.OrNil()
+}
+
+// ObjectIDs returns the IDs of a validated req.
+func (req *BulkPostRequest) ObjectIDs() []bson.ObjectId {
+	ids := make([]bson.ObjectId, 0, len(req.IDs))
+	for _, id := range req.IDs {
+		ids = append(ids, bson.ObjectIdHex(id))
+	}
+	return ids
+}
+
+// Apply makes the change req makes to post and stamps it as edited,
+// reporting whether there was anything to change. It fails if the
+// change would break limits, the post is then left as it was.
+func (req *BulkPostRequest) Apply(post *TextPost, limits *PostLimits, edited time.Time) (bool, error) {
+	switch req.Operation {
+	case BulkPublish, BulkUnpublish:
+		draftMode := req.Operation == BulkUnpublish
+		if post.DraftMode == draftMode {
+			return false, nil
+		}
+		post.DraftMode = draftMode
+	case BulkSetAuthor:
+		if post.Author == req.Author {
+			return false, nil
+		}
+		post.Author = req.Author
+	case BulkAddTags, BulkRemoveTags:
+		tags := make([]string, 0, len(post.Tags)+len(req.Tags))
+		for _, tag := range post.Tags {
+			if req.Operation == BulkAddTags || !containsString(req.Tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+		if req.Operation == BulkAddTags {
+			for _, tag := range req.Tags {
+				if !containsString(post.Tags, tag) {
+					tags = append(tags, tag)
+				}
+			}
+		}
+		if len(tags) == len(post.Tags) {
+			return false, nil
+		}
+		problems := &ValidationError{}
+		limits.check(problems, "", "", tags, time.Time{})
+		if err := problems.OrNil(); err != nil {
+			return false, err
+		}
+		post.Tags = tags
+	}
+	post.Edited = edited
+	return true, nil
+}
+
+// update is the change req makes as a MongoDB update, matching what
+// Apply does to each post.
+func (req *BulkPostRequest) update(edited time.Time) bson.M {
+	switch req.Operation {
+	case BulkPublish:
+		return bson.M{"$set": bson.M{"draftmode": false, "edited": edited}}
+	case BulkUnpublish:
+		return bson.M{"$set": bson.M{"draftmode": true, "edited": edited}}
+	case BulkSetAuthor:
+		return bson.M{"$set": bson.M{"author": req.Author, "edited": edited}}
+	case BulkAddTags:
+		return bson.M{
+			"$addToSet": bson.M{"tags": bson.M{"$each": req.Tags}},
+			"$set":      bson.M{"edited": edited},
+		}
+	case BulkRemoveTags:
+		return bson.M{
+			"$pull": bson.M{"tags": bson.M{"$in": req.Tags}},
+			"$set":  bson.M{"edited": edited},
+		}
+	}
+	panic(fmt.Sprintf("models: no update for bulk operation %q", req.Operation))
+}
+
+// query selects the posts matching filter.
+func (filter *PostFilter) query() bson.M {
+	query := bson.M{}
+	if len(filter.Tag) > 0 {
+		query["tags"] = filter.Tag
+	}
+	if len(filter.Author) > 0 {
+		query["author"] = filter.Author
+	}
+	if filter.DraftMode != nil {
+		query["draftmode"] = *filter.DraftMode
+	}
+	return query
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBulkApply(t *testing.T) {
+	edited := time.Date(2024, 4, 1, 8, 30, 0, 0, time.UTC)
+	limits := &PostLimits{MaxTags: 2}
+	cases := []struct {
+		name     string
+		req      *BulkPostRequest
+		modified bool
+		invalid  bool
+		check    func(*TextPost) bool
+	}{
+		{
+			name:     "publish",
+			req:      &BulkPostRequest{Operation: BulkPublish},
+			modified: true,
+			check:    func(p *TextPost) bool { return !p.DraftMode },
+		},
+		{
+			name: "unpublish a draft",
+			req:  &BulkPostRequest{Operation: BulkUnpublish},
+		},
+		{
+			name:     "add tags",
+			req:      &BulkPostRequest{Operation: BulkAddTags, Tags: []string{"go", "web"}},
+			modified: true,
+			check:    func(p *TextPost) bool { return reflect.DeepEqual(p.Tags, []string{"go", "web"}) },
+		},
+		{
+			name:    "add tags over the limit",
+			req:     &BulkPostRequest{Operation: BulkAddTags, Tags: []string{"web", "api"}},
+			invalid: true,
+		},
+		{
+			name: "remove tags the post does not have",
+			req:  &BulkPostRequest{Operation: BulkRemoveTags, Tags: []string{"web"}},
+		},
+		{
+			name:     "set author",
+			req:      &BulkPostRequest{Operation: BulkSetAuthor, Author: "github:hubot"},
+			modified: true,
+			check:    func(p *TextPost) bool { return p.Author == "github:hubot" },
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			post := testPost()
+			before := *post
+			modified, err := c.req.Apply(post, limits, edited)
+			if c.invalid {
+				if err == nil {
+					t.Fatal("got no error")
+				}
+				if !reflect.DeepEqual(*post, before) {
+					t.Errorf("post changed on failure: %+v", post)
+				}
+				return
+			}
+			if err != nil || modified != c.modified {
+				t.Fatalf("got modified %v and error %v, want %v", modified, err, c.modified)
+			}
+			if !modified {
+				if !reflect.DeepEqual(*post, before) {
+					t.Errorf("post changed without modification: %+v", post)
+				}
+				return
+			}
+			if !post.Edited.Equal(edited) {
+				t.Errorf("got edited %v, want %v", post.Edited, edited)
+			}
+			if !c.check(post) {
+				t.Errorf("got %+v", post)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	mgo "gopkg.in/mgo.v2"
+)
+
+var (
+	// ErrNotFound is returned when the requested record does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a record clashes with one already
+	// stored
+	ErrConflict = errors.New("conflict")
+	// ErrInvalid is returned when a record fails validation, the
+	// error is a *ValidationError saying which fields are wrong
+	ErrInvalid = errors.New("invalid")
+	// ErrUnavailable is returned when the database cannot be reached
+	ErrUnavailable = errors.New("store unavailable")
+)
+
+// FieldError describes what is wrong with one field of a record.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists the fields of a record that failed validation.
+// It matches ErrInvalid with errors.Is.
+type ValidationError struct {
+	Fields []*FieldError
+}
+
+// Add records a problem with field.
+func (ve *ValidationError) Add(field string, message string) {
+	ve.Fields = append(ve.Fields, &FieldError{
+		Field:   field,
+		Message: message,
+	})
+}
+
+// OrNil returns ve if any problems were recorded, nil otherwise.
+func (ve *ValidationError) OrNil() error {
+	if len(ve.Fields) == 0 {
+		return nil
+	}
+	return ve
+}
+
+func (ve *ValidationError) Error() string {
+	problems := make([]string, 0, len(ve.Fields))
+	for _, field := range ve.Fields {
+		problems = append(problems, field.Field+": "+field.Message)
+	}
+	return "error invalid " + strings.Join(problems, ", ")
+}
+
+// Is lets errors.Is(err, ErrInvalid) match validation errors.
+func (ve *ValidationError) Is(target error) bool {
+	return target == ErrInvalid
+}
+
+// invalid returns a ValidationError for a single field.
+func invalid(field string, message string) error {
+	ve := &ValidationError{}
+	ve.Add(field, message)
+	return ve
+}
+
+// storeError wraps an error returned by mgo while doing action with
+// the sentinel error it corresponds to, so callers can tell a missing
+// record from a broken database.
+func storeError(action string, err error) error {
+	switch {
+	case err == mgo.ErrNotFound:
+		return fmt.Errorf("error %s: %w", action, ErrNotFound)
+	case mgo.IsDup(err):
+		return fmt.Errorf("error %s: %w", action, ErrConflict)
+	case isUnavailable(err):
+		return fmt.Errorf("error %s: %w: %v", action, ErrUnavailable, err)
+	}
+	return fmt.Errorf("error %s: %v", action, err)
+}
+
+// isUnavailable reports whether err means the database could not be
+// reached rather than that it rejected the operation.
+func isUnavailable(err error) bool {
+	var netErr net.Error
+	if err == io.EOF || errors.As(err, &netErr) {
+		return true
+	}
+	message := err.Error()
+	return strings.Contains(message, "no reachable servers") ||
+		strings.Contains(message, "Closed explicitly")
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"context"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MongoAuditStore keeps the audit log in a collection of its own.
+type MongoAuditStore struct {
+	session *mgo.Session
+	dbname  string
+	colname string
+	// Observer, when set, is told about every operation
+	Observer StoreObserver
+}
+
+func NewMongoAuditStore(sess *mgo.Session, dbName string, collectionName string) *MongoAuditStore {
+	if sess == nil {
+		panic("nil pointer passed for session")
+	}
+	return &MongoAuditStore{
+		session: sess,
+		dbname:  dbName,
+		colname: collectionName,
+	}
+}
+
+// AppendAudit adds event to the audit log.
+func (as *MongoAuditStore) AppendAudit(ctx context.Context, event *AuditEvent) (err error) {
+	_, end := beginOperation(ctx, as.colname, "append_audit", as.Observer)
+	defer end(&err)
+	col := as.session.DB(as.dbname).C(as.colname)
+	if err := col.Insert(event); err != nil {
+		return storeError("inserting audit event to mongodb", err)
+	}
+	return nil
+}
+
+// FindAudit returns the events matching filter, newest first.
+func (as *MongoAuditStore) FindAudit(ctx context.Context, filter *AuditFilter) (_ []*AuditEvent, err error) {
+	_, end := beginOperation(ctx, as.colname, "find_audit", as.Observer)
+	defer end(&err)
+	query := bson.M{}
+	if len(filter.Actor) > 0 {
+		query["actor"] = filter.Actor
+	}
+	if len(filter.Action) > 0 {
+		query["action"] = filter.Action
+	}
+	if len(filter.PostID) > 0 {
+		query["post"] = filter.PostID
+	}
+	if !filter.Since.IsZero() {
+		query["time"] = bson.M{"$gte": filter.Since}
+	}
+	events := make([]*AuditEvent, 0)
+	col := as.session.DB(as.dbname).C(as.colname)
+	find := col.Find(query).Sort("-time")
+	if filter.Limit > 0 {
+		find = find.Limit(filter.Limit)
+	}
+	if err := find.All(&events); err != nil {
+		return nil, storeError("fetching audit events", err)
+	}
+	return events, nil
+}
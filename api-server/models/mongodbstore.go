@@ -1,7 +1,9 @@
 package models
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	mgo "gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
@@ -11,6 +13,8 @@ type MongoStore struct {
 	session *mgo.Session
 	dbname  string
 	colname string
+	// Observer, when set, is told about every operation
+	Observer StoreObserver
 }
 
 func NewMongoStore(sess *mgo.Session, dbName string, collectionName string) *MongoStore {
@@ -26,43 +30,39 @@ func NewMongoStore(sess *mgo.Session, dbName string, collectionName string) *Mon
 
 // GetTextPostByID returns a TextPost struct for the post with the
 // provided ID.
-func (ms *MongoStore) GetTextPostByID(id bson.ObjectId) (*TextPost, error) {
+func (ms *MongoStore) GetTextPostByID(ctx context.Context, id bson.ObjectId) (_ *TextPost, err error) {
+	_, end := ms.begin(ctx, "get_post")
+	defer end(&err)
 	result := &TextPost{}
 	col := ms.session.DB(ms.dbname).C(ms.colname)
 	if err := col.Find(bson.M{"_id": id}).One(&result); err != nil {
-		return nil, fmt.Errorf("error finding user: %v", err)
+		return nil, storeError("finding post", err)
 	}
 	return result, nil
 }
 
 // Insert writes given post to database.
-func (ms *MongoStore) InsertTextPost(newPost *TextPost) error {
+func (ms *MongoStore) InsertTextPost(ctx context.Context, newPost *TextPost) (err error) {
+	_, end := ms.begin(ctx, "insert_post")
+	defer end(&err)
 	col := ms.session.DB(ms.dbname).C(ms.colname)
 	if err := col.Insert(newPost); err != nil {
-		return fmt.Errorf("error inserting new post to mongodb: %v", err)
+		return storeError("inserting new post to mongodb", err)
 	}
 	return nil
 }
 
 // FetchAllShort returns slice of all posts excluding their
 // body field.
-func (ms *MongoStore) FetchAllShort(drafts bool) ([]*PostShort, error) {
+func (ms *MongoStore) FetchAllShort(ctx context.Context, drafts bool) (_ []*PostShort, err error) {
+	_, end := ms.begin(ctx, "list_posts")
+	defer end(&err)
 	longPost := &TextPost{}
 	shortSlice := make([]*PostShort, 0)
 	col := ms.session.DB(ms.dbname).C(ms.colname)
 	iterVal := col.Find(bson.M{}).Iter()
 	for iterVal.Next(longPost) {
-		postShort := &PostShort{
-			ID:        longPost.ID,
-			Author:    longPost.Author,
-			Title:     longPost.Title,
-			Created:   longPost.Created,
-			Edited:    longPost.Edited,
-			Publish:   longPost.Publish,
-			DraftMode: longPost.DraftMode,
-			Tags:      longPost.Tags,
-			Views:     longPost.Views,
-		}
+		postShort := longPost.Short()
 		// if drafts == true, add everything
 		if drafts {
 			shortSlice = append(shortSlice, postShort)
@@ -71,40 +71,137 @@ func (ms *MongoStore) FetchAllShort(drafts bool) ([]*PostShort, error) {
 		}
 	}
 	if err := iterVal.Err(); err != nil {
-		return nil, err
+		return nil, storeError("fetching posts", err)
 	}
 	return shortSlice, nil
 }
 
 // DeleteTextPost will delete post with given ID
-func (ms *MongoStore) DeletePost(postID bson.ObjectId) error {
+func (ms *MongoStore) DeletePost(ctx context.Context, postID bson.ObjectId) (err error) {
+	_, end := ms.begin(ctx, "delete_post")
+	defer end(&err)
 	col := ms.session.DB(ms.dbname).C(ms.colname)
 	if err := col.RemoveId(postID); err != nil {
-		return fmt.Errorf("error deleting post: %v", err)
+		return storeError("deleting post", err)
 	}
 	return nil
 }
 
-func (ms *MongoStore) UpdateTextPost(postID bson.ObjectId, updates *TextPostUpdates) (*TextPost, error) {
-	postToUpdate, err := ms.GetTextPostByID(postID)
-	if err != nil {
-		return nil, err
-	}
-	if err := postToUpdate.ApplyUpdates(updates); err != nil {
-		return nil, fmt.Errorf("error applying updates to post: %v", err)
+// UpdateTextPost writes only the fields changes lists to the post with
+// the given ID, and when it was edited, returning the post as stored.
+// Fields left out keep whatever concurrent updates wrote to them.
+func (ms *MongoStore) UpdateTextPost(ctx context.Context, postID bson.ObjectId, changes []*FieldChange, edited time.Time) (_ *TextPost, err error) {
+	_, end := ms.begin(ctx, "update_post")
+	defer end(&err)
+	set := bson.M{"edited": edited}
+	for _, field := range changes {
+		set[field.Field] = field.After
 	}
-
 	change := mgo.Change{
-		Update:    bson.M{"$set": postToUpdate},
+		Update:    bson.M{"$set": set},
 		ReturnNew: true,
 	}
-	// TODO: NEED TO TEST IF THIS ERASES POST BODY
 	result := &TextPost{}
 	col := ms.session.DB(ms.dbname).C(ms.colname)
 	if _, err := col.FindId(postID).Apply(change, result); err != nil {
-		return nil, fmt.Errorf("error updating record: %v", err)
+		return nil, storeError("updating record", err)
 	}
 	return result, nil
 }
 
+// FindTextPosts returns the posts with the given IDs, or up to limit
+// posts matching filter when there are none.
+func (ms *MongoStore) FindTextPosts(ctx context.Context, ids []bson.ObjectId, filter *PostFilter, limit int) (_ []*TextPost, err error) {
+	_, end := ms.begin(ctx, "find_posts")
+	defer end(&err)
+	query := bson.M{"_id": bson.M{"$in": ids}}
+	if len(ids) == 0 {
+		query = filter.query()
+	}
+	posts := make([]*TextPost, 0)
+	col := ms.session.DB(ms.dbname).C(ms.colname)
+	if err := col.Find(query).Sort("-created").Limit(limit).All(&posts); err != nil {
+		return nil, storeError("finding posts", err)
+	}
+	return posts, nil
+}
+
+// BulkUpdateTextPosts applies req to posts in a single write, deleting
+// them for trash and otherwise stamping them as edited, and returns
+// the IDs of the posts it changed. Posts edited since they were read
+// are left alone. MongoDB may stop partway through if the write fails,
+// the posts changed are then returned with the error when they can be
+// found, and nil when they cannot.
+func (ms *MongoStore) BulkUpdateTextPosts(ctx context.Context, posts []*TextPost, req *BulkPostRequest, edited time.Time) (_ []bson.ObjectId, err error) {
+	_, end := ms.begin(ctx, "bulk_update_posts")
+	defer end(&err)
+	ids := make([]bson.ObjectId, 0, len(posts))
+	unchanged := make([]bson.M, 0, len(posts))
+	for _, post := range posts {
+		ids = append(ids, post.ID)
+		var readEdited interface{} = post.Edited
+		if post.Edited.IsZero() {
+			// posts never edited may not have the field at all
+			readEdited = bson.M{"$in": []interface{}{post.Edited, nil}}
+		}
+		unchanged = append(unchanged, bson.M{"_id": post.ID, "edited": readEdited})
+	}
+	col := ms.session.DB(ms.dbname).C(ms.colname)
+	var writeErr error
+	if req.Operation == BulkTrash {
+		_, writeErr = col.RemoveAll(bson.M{"$or": unchanged})
+	} else {
+		_, writeErr = col.UpdateAll(bson.M{"$or": unchanged}, req.update(edited))
+	}
+
+	// the write does not say which posts it reached, so look
+	var left []*TextPost
+	if err := col.Find(bson.M{"_id": bson.M{"$in": ids}}).Select(bson.M{"edited": 1}).All(&left); err != nil {
+		if writeErr != nil {
+			return nil, storeError("updating posts", writeErr)
+		}
+		return nil, storeError("checking updated posts", err)
+	}
+	found := make(map[bson.ObjectId]bool, len(left))
+	changed := make([]bson.ObjectId, 0, len(ids))
+	for _, post := range left {
+		found[post.ID] = true
+		if req.Operation != BulkTrash && post.Edited.Equal(edited) {
+			changed = append(changed, post.ID)
+		}
+	}
+	if req.Operation == BulkTrash {
+		for _, id := range ids {
+			if !found[id] {
+				changed = append(changed, id)
+			}
+		}
+	}
+	if writeErr != nil {
+		return changed, storeError("updating posts", writeErr)
+	}
+	return changed, nil
+}
+
+// Ping checks the database can be reached, giving up once ctx is
+// done.
+func (ms *MongoStore) Ping(ctx context.Context) error {
+	result := make(chan error, 1)
+	go func() {
+		// a copy dials a fresh socket rather than reusing a dead one
+		session := ms.session.Copy()
+		defer session.Close()
+		result <- session.Ping()
+	}()
+	select {
+	case err := <-result:
+		if err != nil {
+			return storeError("pinging database", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("error pinging database: %w: %v", ErrUnavailable, ctx.Err())
+	}
+}
+
 // TODO: Backup database often and export "off-site"
@@ -0,0 +1,180 @@
+package models
+
+import (
+	"fmt"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MongoUserStore keeps the users allowed to sign in and the
+// organization rules that admit users in bulk.
+type MongoUserStore struct {
+	session  *mgo.Session
+	dbname   string
+	usersCol string
+	rulesCol string
+}
+
+func NewMongoUserStore(sess *mgo.Session, dbName string, usersCollection string, rulesCollection string) *MongoUserStore {
+	if sess == nil {
+		panic("nil pointer passed for session")
+	}
+	return &MongoUserStore{
+		session:  sess,
+		dbname:   dbName,
+		usersCol: usersCollection,
+		rulesCol: rulesCollection,
+	}
+}
+
+// EnsureIndexes creates the unique index that stops a login being
+// stored twice for the same provider.
+func (us *MongoUserStore) EnsureIndexes() error {
+	col := us.session.DB(us.dbname).C(us.usersCol)
+	if err := col.EnsureIndex(mgo.Index{Key: []string{"provider", "login"}, Unique: true}); err != nil {
+		return storeError("creating user index", err)
+	}
+	return nil
+}
+
+// GetUser returns the user with the given login at provider.
+func (us *MongoUserStore) GetUser(provider string, login string) (*User, error) {
+	result := &User{}
+	col := us.session.DB(us.dbname).C(us.usersCol)
+	if err := col.Find(bson.M{"provider": provider, "login": login}).One(result); err != nil {
+		return nil, storeError("finding user", err)
+	}
+	return result, nil
+}
+
+// AllUsers returns every user allowed to sign in.
+func (us *MongoUserStore) AllUsers() ([]*User, error) {
+	users := make([]*User, 0)
+	col := us.session.DB(us.dbname).C(us.usersCol)
+	if err := col.Find(bson.M{}).Sort("provider", "login").All(&users); err != nil {
+		return nil, storeError("fetching users", err)
+	}
+	return users, nil
+}
+
+// CountUsers returns how many users are stored.
+func (us *MongoUserStore) CountUsers() (int, error) {
+	col := us.session.DB(us.dbname).C(us.usersCol)
+	count, err := col.Count()
+	if err != nil {
+		return 0, storeError("counting users", err)
+	}
+	return count, nil
+}
+
+// CountAdmins returns how many stored users are admins.
+func (us *MongoUserStore) CountAdmins() (int, error) {
+	col := us.session.DB(us.dbname).C(us.usersCol)
+	count, err := col.Find(bson.M{"role": RoleAdmin}).Count()
+	if err != nil {
+		return 0, storeError("counting admins", err)
+	}
+	return count, nil
+}
+
+// InsertUser adds newUser, failing if the login is already present
+// for that provider.
+func (us *MongoUserStore) InsertUser(newUser *User) error {
+	col := us.session.DB(us.dbname).C(us.usersCol)
+	if err := col.Insert(newUser); err != nil {
+		if mgo.IsDup(err) {
+			return fmt.Errorf("error user %s:%s already exists: %w", newUser.Provider, newUser.Login, ErrConflict)
+		}
+		return storeError("inserting new user to mongodb", err)
+	}
+	return nil
+}
+
+// UpdateUser applies updates to the user and returns the result. The
+// last admin cannot be demoted.
+func (us *MongoUserStore) UpdateUser(provider string, login string, updates *UserUpdates) (*User, error) {
+	if !ValidRole(updates.Role) {
+		return nil, invalid("role", fmt.Sprintf("unknown role %q", updates.Role))
+	}
+	change := mgo.Change{
+		Update: bson.M{"$set": bson.M{"role": updates.Role}},
+	}
+	result := &User{}
+	col := us.session.DB(us.dbname).C(us.usersCol)
+	if _, err := col.Find(bson.M{"provider": provider, "login": login}).Apply(change, result); err != nil {
+		return nil, storeError("updating user", err)
+	}
+	if result.Role == RoleAdmin && updates.Role != RoleAdmin {
+		err := us.keepAdmin("demote", func() error {
+			return col.Update(bson.M{"provider": provider, "login": login, "role": updates.Role}, bson.M{"$set": bson.M{"role": RoleAdmin}})
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	result.Role = updates.Role
+	return result, nil
+}
+
+// DeleteUser removes the user with the given login at provider. The
+// last admin cannot be deleted.
+func (us *MongoUserStore) DeleteUser(provider string, login string) error {
+	removed := &User{}
+	col := us.session.DB(us.dbname).C(us.usersCol)
+	if _, err := col.Find(bson.M{"provider": provider, "login": login}).Apply(mgo.Change{Remove: true}, removed); err != nil {
+		return storeError("deleting user", err)
+	}
+	if removed.Role == RoleAdmin {
+		return us.keepAdmin("delete", func() error {
+			return col.Insert(removed)
+		})
+	}
+	return nil
+}
+
+// keepAdmin is called after a change took an admin away and calls undo
+// when no admin is left. Checking after the change rather than before
+// means two admins removing each other cannot both succeed.
+func (us *MongoUserStore) keepAdmin(action string, undo func() error) error {
+	admins, err := us.CountAdmins()
+	if err == nil && admins > 0 {
+		return nil
+	}
+	// the user may have been changed again since, leave them be
+	if undoErr := undo(); undoErr != nil && undoErr != mgo.ErrNotFound && !mgo.IsDup(undoErr) {
+		return storeError("restoring the last admin", undoErr)
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("error cannot %s the last admin: %w", action, ErrConflict)
+}
+
+// AllRules returns every organization access rule.
+func (us *MongoUserStore) AllRules() ([]*AccessRule, error) {
+	rules := make([]*AccessRule, 0)
+	col := us.session.DB(us.dbname).C(us.rulesCol)
+	if err := col.Find(bson.M{}).Sort("org", "team").All(&rules); err != nil {
+		return nil, storeError("fetching access rules", err)
+	}
+	return rules, nil
+}
+
+// InsertRule adds a new organization access rule.
+func (us *MongoUserStore) InsertRule(newRule *AccessRule) error {
+	col := us.session.DB(us.dbname).C(us.rulesCol)
+	if err := col.Insert(newRule); err != nil {
+		return storeError("inserting new access rule to mongodb", err)
+	}
+	return nil
+}
+
+// DeleteRule removes the access rule with the given ID.
+func (us *MongoUserStore) DeleteRule(ruleID bson.ObjectId) error {
+	col := us.session.DB(us.dbname).C(us.rulesCol)
+	if err := col.RemoveId(ruleID); err != nil {
+		return storeError("deleting access rule", err)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MongoWebhookStore keeps webhooks and the queue of deliveries to
+// them, which doubles as the delivery log.
+type MongoWebhookStore struct {
+	session       *mgo.Session
+	dbname        string
+	webhooksCol   string
+	deliveriesCol string
+	// Observer, when set, is told about every operation
+	Observer StoreObserver
+}
+
+func NewMongoWebhookStore(sess *mgo.Session, dbName string, webhooksCollection string, deliveriesCollection string) *MongoWebhookStore {
+	if sess == nil {
+		panic("nil pointer passed for session")
+	}
+	return &MongoWebhookStore{
+		session:       sess,
+		dbname:        dbName,
+		webhooksCol:   webhooksCollection,
+		deliveriesCol: deliveriesCollection,
+	}
+}
+
+// AllWebhooks returns every webhook.
+func (ws *MongoWebhookStore) AllWebhooks(ctx context.Context) (_ []*Webhook, err error) {
+	_, end := beginOperation(ctx, ws.webhooksCol, "list_webhooks", ws.Observer)
+	defer end(&err)
+	webhooks := make([]*Webhook, 0)
+	col := ws.session.DB(ws.dbname).C(ws.webhooksCol)
+	if err := col.Find(bson.M{}).Sort("added").All(&webhooks); err != nil {
+		return nil, storeError("fetching webhooks", err)
+	}
+	return webhooks, nil
+}
+
+// WebhooksFor returns the webhooks subscribed to event.
+func (ws *MongoWebhookStore) WebhooksFor(ctx context.Context, event string) (_ []*Webhook, err error) {
+	_, end := beginOperation(ctx, ws.webhooksCol, "find_webhooks", ws.Observer)
+	defer end(&err)
+	webhooks := make([]*Webhook, 0)
+	col := ws.session.DB(ws.dbname).C(ws.webhooksCol)
+	if err := col.Find(bson.M{"events": event}).All(&webhooks); err != nil {
+		return nil, storeError("fetching webhooks", err)
+	}
+	return webhooks, nil
+}
+
+// GetWebhook returns the webhook with the given ID.
+func (ws *MongoWebhookStore) GetWebhook(ctx context.Context, webhookID bson.ObjectId) (_ *Webhook, err error) {
+	_, end := beginOperation(ctx, ws.webhooksCol, "get_webhook", ws.Observer)
+	defer end(&err)
+	result := &Webhook{}
+	col := ws.session.DB(ws.dbname).C(ws.webhooksCol)
+	if err := col.FindId(webhookID).One(result); err != nil {
+		return nil, storeError("finding webhook", err)
+	}
+	return result, nil
+}
+
+// InsertWebhook adds a new webhook.
+func (ws *MongoWebhookStore) InsertWebhook(ctx context.Context, webhook *Webhook) (err error) {
+	_, end := beginOperation(ctx, ws.webhooksCol, "insert_webhook", ws.Observer)
+	defer end(&err)
+	col := ws.session.DB(ws.dbname).C(ws.webhooksCol)
+	if err := col.Insert(webhook); err != nil {
+		return storeError("inserting new webhook to mongodb", err)
+	}
+	return nil
+}
+
+// DeleteWebhook removes the webhook with the given ID. Its deliveries
+// are kept for the log, pending ones fail when next attempted.
+func (ws *MongoWebhookStore) DeleteWebhook(ctx context.Context, webhookID bson.ObjectId) (err error) {
+	_, end := beginOperation(ctx, ws.webhooksCol, "delete_webhook", ws.Observer)
+	defer end(&err)
+	col := ws.session.DB(ws.dbname).C(ws.webhooksCol)
+	if err := col.RemoveId(webhookID); err != nil {
+		return storeError("deleting webhook", err)
+	}
+	return nil
+}
+
+// InsertDelivery queues a delivery.
+func (ws *MongoWebhookStore) InsertDelivery(ctx context.Context, delivery *WebhookDelivery) (err error) {
+	_, end := beginOperation(ctx, ws.deliveriesCol, "insert_delivery", ws.Observer)
+	defer end(&err)
+	col := ws.session.DB(ws.dbname).C(ws.deliveriesCol)
+	if err := col.Insert(delivery); err != nil {
+		return storeError("inserting webhook delivery to mongodb", err)
+	}
+	return nil
+}
+
+// ClaimDelivery returns the pending delivery that has waited longest
+// for its next attempt, or ErrNotFound if none is due. The claimed
+// delivery is not handed out again until lease has passed, so a
+// delivery whose worker died is retried.
+func (ws *MongoWebhookStore) ClaimDelivery(ctx context.Context, now time.Time, lease time.Duration) (_ *WebhookDelivery, err error) {
+	_, end := beginOperation(ctx, ws.deliveriesCol, "claim_delivery", ws.Observer)
+	defer end(&err)
+	change := mgo.Change{
+		Update:    bson.M{"$set": bson.M{"nextattempt": now.Add(lease)}},
+		ReturnNew: true,
+	}
+	result := &WebhookDelivery{}
+	col := ws.session.DB(ws.dbname).C(ws.deliveriesCol)
+	query := bson.M{"status": DeliveryPending, "nextattempt": bson.M{"$lte": now}}
+	if _, err := col.Find(query).Sort("nextattempt").Apply(change, result); err != nil {
+		return nil, storeError("claiming webhook delivery", err)
+	}
+	return result, nil
+}
+
+// RecordAttempt adds attempt to the delivery's log and sets its status
+// and when it is next attempted.
+func (ws *MongoWebhookStore) RecordAttempt(ctx context.Context, deliveryID bson.ObjectId, attempt *DeliveryAttempt, status string, nextAttempt time.Time) (err error) {
+	_, end := beginOperation(ctx, ws.deliveriesCol, "record_attempt", ws.Observer)
+	defer end(&err)
+	col := ws.session.DB(ws.dbname).C(ws.deliveriesCol)
+	update := bson.M{
+		"$push": bson.M{"attempts": attempt},
+		"$set":  bson.M{"status": status, "nextattempt": nextAttempt},
+	}
+	if err := col.UpdateId(deliveryID, update); err != nil {
+		return storeError("recording webhook delivery attempt", err)
+	}
+	return nil
+}
+
+// GetDelivery returns the delivery with the given ID.
+func (ws *MongoWebhookStore) GetDelivery(ctx context.Context, deliveryID bson.ObjectId) (_ *WebhookDelivery, err error) {
+	_, end := beginOperation(ctx, ws.deliveriesCol, "get_delivery", ws.Observer)
+	defer end(&err)
+	result := &WebhookDelivery{}
+	col := ws.session.DB(ws.dbname).C(ws.deliveriesCol)
+	if err := col.FindId(deliveryID).One(result); err != nil {
+		return nil, storeError("finding webhook delivery", err)
+	}
+	return result, nil
+}
+
+// DeliveriesFor returns up to limit of the newest deliveries to the
+// webhook with the given ID.
+func (ws *MongoWebhookStore) DeliveriesFor(ctx context.Context, webhookID bson.ObjectId, limit int) (_ []*WebhookDelivery, err error) {
+	_, end := beginOperation(ctx, ws.deliveriesCol, "list_deliveries", ws.Observer)
+	defer end(&err)
+	deliveries := make([]*WebhookDelivery, 0)
+	col := ws.session.DB(ws.dbname).C(ws.deliveriesCol)
+	if err := col.Find(bson.M{"webhook": webhookID}).Sort("-created").Limit(limit).All(&deliveries); err != nil {
+		return nil, storeError("fetching webhook deliveries", err)
+	}
+	return deliveries, nil
+}
+
+// RequeueDelivery makes the delivery with the given ID pending again,
+// due now.
+func (ws *MongoWebhookStore) RequeueDelivery(ctx context.Context, deliveryID bson.ObjectId) (err error) {
+	_, end := beginOperation(ctx, ws.deliveriesCol, "requeue_delivery", ws.Observer)
+	defer end(&err)
+	col := ws.session.DB(ws.dbname).C(ws.deliveriesCol)
+	update := bson.M{"$set": bson.M{"status": DeliveryPending, "nextattempt": time.Now()}}
+	if err := col.UpdateId(deliveryID, update); err != nil {
+		return storeError("requeueing webhook delivery", err)
+	}
+	return nil
+}
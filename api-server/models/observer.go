@@ -0,0 +1,58 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/KyleWS/blog-api/api-server/logging"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/KyleWS/blog-api/api-server/models")
+
+// StoreObserver is told how long a store operation took and the error
+// it returned, if any.
+type StoreObserver func(operation string, duration time.Duration, err error)
+
+// begin starts a span for an operation on the posts collection. The
+// returned function ends it and reports the operation to the
+// observer, err points at the operation's error result.
+func (ms *MongoStore) begin(ctx context.Context, operation string) (context.Context, func(err *error)) {
+	return beginOperation(ctx, ms.colname, operation, ms.Observer)
+}
+
+// beginOperation starts a span for an operation on collection, the
+// returned function ends it and reports the operation to observer if
+// there is one.
+func beginOperation(ctx context.Context, collection string, operation string, observer StoreObserver) (context.Context, func(err *error)) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "mongodb "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemNameMongoDB,
+			semconv.DBOperationName(operation),
+			semconv.DBCollectionName(collection),
+		),
+	)
+	return ctx, func(err *error) {
+		// a missing record is the caller's problem, not the store's
+		if *err != nil && !errors.Is(*err, ErrNotFound) {
+			span.RecordError(*err)
+			span.SetStatus(codes.Error, (*err).Error())
+		}
+		span.End()
+		logging.FromContext(ctx).WithFields(logrus.Fields{
+			"operation": operation,
+			"duration":  time.Since(start).String(),
+			"err":       *err,
+		}).Debug("store operation")
+		if observer != nil {
+			observer(operation, time.Since(start), *err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fields of a post in its JSON that patches may not change, edited is
+// stamped whenever a post changes
+var immutablePostFields = map[string]bool{
+	"id":      true,
+	"author":  true,
+	"created": true,
+	"edited":  true,
+	"views":   true,
+}
+
+// fields of a post in its JSON that patches may change but not clear
+var requiredPostFields = map[string]bool{
+	"title":     true,
+	"draftmode": true,
+}
+
+// Patched returns the post described by patched, the JSON of tp after a
+// JSON Patch or merge patch was applied to it. Body, publish and tags
+// may be cleared, by removing them or setting them to null, fields
+// users cannot change must be left as they were.
+func (tp *TextPost) Patched(patched []byte) (*TextPost, error) {
+	original, err := json.Marshal(tp)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding post: %v", err)
+	}
+	var before, after map[string]json.RawMessage
+	if err := json.Unmarshal(original, &before); err != nil {
+		return nil, fmt.Errorf("error decoding post: %v", err)
+	}
+	if err := json.Unmarshal(patched, &after); err != nil || after == nil {
+		return nil, invalid("patch", "must leave the post a JSON object")
+	}
+
+	problems := &ValidationError{}
+	names := make([]string, 0, len(before)+len(after))
+	for name := range before {
+		names = append(names, name)
+	}
+	for name := range after {
+		if _, found := before[name]; !found {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value, found := after[name]
+		_, known := before[name]
+		cleared := !found || bytes.Equal(value, []byte("null"))
+		switch {
+		case !known:
+			problems.Add(name, "is not a known field")
+		case immutablePostFields[name] && !sameJSON(before[name], value):
+			problems.Add(name, "cannot be changed")
+		case requiredPostFields[name] && cleared:
+			problems.Add(name, "cannot be cleared")
+		}
+	}
+	if err := problems.OrNil(); err != nil {
+		return nil, err
+	}
+
+	result := &TextPost{}
+	if err := json.Unmarshal(patched, result); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return nil, invalid(typeErr.Field, fmt.Sprintf("cannot be a JSON %s", typeErr.Value))
+		}
+		return nil, invalid("patch", err.Error())
+	}
+	// the JSON holds the same times in another location, keep those of
+	// tp so unchanged times compare equal
+	result.ID, result.Author, result.Views = tp.ID, tp.Author, tp.Views
+	result.Created, result.Edited = tp.Created, tp.Edited
+	if result.Publish.Equal(tp.Publish) {
+		result.Publish = tp.Publish
+	}
+	// cleared tags are stored as an empty list, like those of new posts
+	if result.Tags == nil && tp.Tags != nil {
+		result.Tags = make([]string, 0)
+	}
+	return result, nil
+}
+
+// ValidateChanges checks the fields tp changes from before are within
+// limits. Fields left as they were are not checked, so posts written
+// before a limit was lowered can still be edited.
+func (tp *TextPost) ValidateChanges(before *TextPost, limits *PostLimits) error {
+	problems := &ValidationError{}
+	var title, body string
+	var tags []string
+	var publish time.Time
+	for _, change := range DiffPosts(before, tp) {
+		switch change.Field {
+		case "title":
+			title = tp.Title
+			if len(strings.TrimSpace(title)) == 0 {
+				problems.Add("title", "cannot be blank")
+			}
+		case "body":
+			body = tp.Body
+		case "tags":
+			tags = tp.Tags
+		case "publish":
+			publish = tp.Publish
+		}
+	}
+	limits.check(problems, title, body, tags, publish)
+	return problems.OrNil()
+}
+
+// sameJSON reports whether two JSON values are equal, however they are
+// spaced.
+func sameJSON(a json.RawMessage, b json.RawMessage) bool {
+	var left, right interface{}
+	if json.Unmarshal(a, &left) != nil || json.Unmarshal(b, &right) != nil {
+		return false
+	}
+	return reflect.DeepEqual(left, right)
+}
@@ -0,0 +1,165 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func testPost() *TextPost {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	return &TextPost{
+		ID:        bson.ObjectIdHex("5f1d7a0c9b1e8a3d2c4b6a10"),
+		Author:    "github:octocat",
+		Title:     "Hello",
+		Created:   created,
+		Edited:    created,
+		Publish:   created,
+		DraftMode: true,
+		Body:      "first",
+		Tags:      []string{"go"},
+		Views:     3,
+	}
+}
+
+// patchJSON returns the JSON of post with fields set to the given JSON
+// values, and removed when a value is empty.
+func patchJSON(t *testing.T, post *TextPost, fields map[string]string) []byte {
+	t.Helper()
+	encoded, err := json.Marshal(post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &object); err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range fields {
+		if len(value) == 0 {
+			delete(object, name)
+			continue
+		}
+		object[name] = json.RawMessage(value)
+	}
+	patched, err := json.Marshal(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return patched
+}
+
+func TestPatched(t *testing.T) {
+	cases := []struct {
+		name   string
+		fields map[string]string
+		// changes are the fields DiffPosts reports, nil when invalid
+		// lists the fields that must fail instead
+		changes []string
+		invalid []string
+	}{
+		{
+			name:   "nothing changed",
+			fields: map[string]string{},
+		},
+		{
+			name:    "title and draftmode",
+			fields:  map[string]string{"title": `"Hi"`, "draftmode": `false`},
+			changes: []string{"title", "draftmode"},
+		},
+		{
+			name:    "same publish time in another zone",
+			fields:  map[string]string{"publish": `"2024-03-01T14:00:00+02:00"`, "body": `"second"`},
+			changes: []string{"body"},
+		},
+		{
+			name:    "body and tags cleared",
+			fields:  map[string]string{"body": "", "tags": `null`},
+			changes: []string{"body", "tags"},
+		},
+		{
+			name:    "immutable fields",
+			fields:  map[string]string{"author": `"github:mallory"`, "views": `100`, "edited": `"2030-01-01T00:00:00Z"`},
+			invalid: []string{"author", "edited", "views"},
+		},
+		{
+			name:    "immutable field removed",
+			fields:  map[string]string{"id": ""},
+			invalid: []string{"id"},
+		},
+		{
+			name:    "required fields cleared",
+			fields:  map[string]string{"title": "", "draftmode": `null`},
+			invalid: []string{"draftmode", "title"},
+		},
+		{
+			name:    "unknown field",
+			fields:  map[string]string{"pinned": `true`},
+			invalid: []string{"pinned"},
+		},
+		{
+			name:    "wrong type",
+			fields:  map[string]string{"title": `7`},
+			invalid: []string{"title"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			post := testPost()
+			result, err := post.Patched(patchJSON(t, post, c.fields))
+			if len(c.invalid) > 0 {
+				var validationErr *ValidationError
+				if !errors.As(err, &validationErr) {
+					t.Fatalf("got error %v, want a validation error", err)
+				}
+				fields := make([]string, 0, len(validationErr.Fields))
+				for _, field := range validationErr.Fields {
+					fields = append(fields, field.Field)
+				}
+				if !reflect.DeepEqual(fields, c.invalid) {
+					t.Errorf("got problems with %v, want %v", fields, c.invalid)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			changed := make([]string, 0)
+			for _, change := range DiffPosts(post, result) {
+				changed = append(changed, change.Field)
+			}
+			if c.changes == nil {
+				c.changes = []string{}
+			}
+			if !reflect.DeepEqual(changed, c.changes) {
+				t.Errorf("got changes to %v, want %v", changed, c.changes)
+			}
+			if result.ID != post.ID || result.Author != post.Author || result.Views != post.Views || !result.Created.Equal(post.Created) {
+				t.Errorf("immutable fields changed: %+v", result)
+			}
+		})
+	}
+}
+
+func TestPatchedClearedTagsAreEmpty(t *testing.T) {
+	post := testPost()
+	result, err := post.Patched(patchJSON(t, post, map[string]string{"tags": ""}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Tags == nil || len(result.Tags) != 0 {
+		t.Errorf("got tags %#v, want an empty list", result.Tags)
+	}
+}
+
+func TestPatchedRejectsNonObjects(t *testing.T) {
+	for _, patched := range []string{`null`, `[]`, `"post"`} {
+		var validationErr *ValidationError
+		if _, err := testPost().Patched([]byte(patched)); !errors.As(err, &validationErr) {
+			t.Errorf("%s: got error %v, want a validation error", patched, err)
+		}
+	}
+}
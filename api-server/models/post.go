@@ -1,8 +1,13 @@
 package models
 
 import (
+	"fmt"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/KyleWS/blog-api/api-server/sanitize"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -17,6 +22,9 @@ type TextPost struct {
 	Body      string        `json:"body"`
 	Tags      []string      `json:"tags"`
 	Views     int           `json:"views"`
+	// Sanitized is what was taken out of the body when it was saved,
+	// only sent back to the author saving it
+	Sanitized []*sanitize.Removal `json:"sanitized,omitempty" bson:"-"`
 }
 
 type UserTextPost struct {
@@ -28,14 +36,14 @@ type UserTextPost struct {
 	Tags      []string  `json:"tags"`
 }
 
-// TextPostUpdates reflects the certain fields of a text post that are
-// mutable.
+// TextPostUpdates are the fields of a text post that can be changed,
+// as sent in a JSON merge patch. Null clears body, publish and tags.
 type TextPostUpdates struct {
-	Title     string    `json:"title"`
-	Publish   time.Time `json:"publish"` // Can set to publish in future
-	DraftMode bool      `json:"draftmode"`
-	Body      string    `json:"body"`
-	Tags      []string  `json:"tags"`
+	Title     *string    `json:"title"`
+	Publish   *time.Time `json:"publish"` // Can set to publish in future
+	DraftMode *bool      `json:"draftmode"`
+	Body      *string    `json:"body"`
+	Tags      []string   `json:"tags"`
 }
 
 // PostShort is used to display all the posts without having to
@@ -52,6 +60,81 @@ type PostShort struct {
 	Views     int           `json:"views"`
 }
 
+// PostLimits bounds what a post may hold, zero leaves a limit off.
+type PostLimits struct {
+	// MaxTitleLength and MaxTagLength are in characters
+	MaxTitleLength int
+	MaxBodyBytes   int
+	MaxTags        int
+	MaxTagLength   int
+	// MaxPublishAhead is how far in the future posts may be scheduled
+	MaxPublishAhead time.Duration
+}
+
+// earliestPublish is the earliest publish time that is not a mistake
+var earliestPublish = time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Validate checks utp is a post that may be created within limits.
+func (utp *UserTextPost) Validate(limits *PostLimits) error {
+	problems := &ValidationError{}
+	if len(strings.TrimSpace(utp.Author)) == 0 {
+		problems.Add("author", "is required")
+	}
+	if len(strings.TrimSpace(utp.Title)) == 0 {
+		problems.Add("title", "is required")
+	}
+	limits.check(problems, utp.Title, utp.Body, utp.Tags, utp.Publish)
+	return problems.OrNil()
+}
+
+// check adds the ways the fields of a post break limits to problems.
+func (limits *PostLimits) check(problems *ValidationError, title string, body string, tags []string, publish time.Time) {
+	if limits.MaxTitleLength > 0 && utf8.RuneCountInString(title) > limits.MaxTitleLength {
+		problems.Add("title", fmt.Sprintf("must be at most %d characters", limits.MaxTitleLength))
+	}
+	if limits.MaxBodyBytes > 0 && len(body) > limits.MaxBodyBytes {
+		problems.Add("body", fmt.Sprintf("must be at most %d bytes", limits.MaxBodyBytes))
+	}
+	if limits.MaxTags > 0 && len(tags) > limits.MaxTags {
+		problems.Add("tags", fmt.Sprintf("must be at most %d tags", limits.MaxTags))
+	}
+	seen := make(map[string]bool, len(tags))
+	for i, tag := range tags {
+		field := fmt.Sprintf("tags.%d", i)
+		switch {
+		case !validTag(tag):
+			problems.Add(field, "must be letters, digits, spaces and - _ . + # without spaces at either end")
+		case limits.MaxTagLength > 0 && utf8.RuneCountInString(tag) > limits.MaxTagLength:
+			problems.Add(field, fmt.Sprintf("must be at most %d characters", limits.MaxTagLength))
+		case seen[strings.ToLower(tag)]:
+			problems.Add(field, fmt.Sprintf("duplicates tag %q", tag))
+		}
+		seen[strings.ToLower(tag)] = true
+	}
+	if !publish.IsZero() {
+		if publish.Before(earliestPublish) {
+			problems.Add("publish", "cannot be before 1970")
+		}
+		if limits.MaxPublishAhead > 0 && publish.After(time.Now().Add(limits.MaxPublishAhead)) {
+			problems.Add("publish", fmt.Sprintf("cannot be more than %s ahead", limits.MaxPublishAhead))
+		}
+	}
+}
+
+// validTag reports whether tag is made of the characters tags may
+// hold.
+func validTag(tag string) bool {
+	if len(tag) == 0 || strings.TrimSpace(tag) != tag {
+		return false
+	}
+	for _, c := range tag {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && !strings.ContainsRune(" -_.+#", c) {
+			return false
+		}
+	}
+	return true
+}
+
 func NewTextPost(author string, title string, body string) *TextPost {
 	return &TextPost{
 		ID:        bson.NewObjectId(),
@@ -75,19 +158,17 @@ func (utp *UserTextPost) GenPostMetaData() *TextPost {
 	return newPost
 }
 
-func (tp *TextPost) ApplyUpdates(updates *TextPostUpdates) error {
-	if len(updates.Body) > 0 {
-		tp.Body = updates.Body
-	}
-	tp.DraftMode = updates.DraftMode
-	if !updates.Publish.IsZero() {
-		tp.Publish = updates.Publish
-	}
-	if len(updates.Tags) > 0 {
-		tp.Tags = updates.Tags
-	}
-	if len(updates.Title) > 0 {
-		tp.Title = updates.Title
+// Short returns tp without its body.
+func (tp *TextPost) Short() *PostShort {
+	return &PostShort{
+		ID:        tp.ID,
+		Author:    tp.Author,
+		Title:     tp.Title,
+		Created:   tp.Created,
+		Edited:    tp.Edited,
+		Publish:   tp.Publish,
+		DraftMode: tp.DraftMode,
+		Tags:      tp.Tags,
+		Views:     tp.Views,
 	}
-	return nil
 }
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// RoleAdmin users can manage posts and who may sign in
+	RoleAdmin = "admin"
+	// RoleWriter users can manage posts
+	RoleWriter = "writer"
+)
+
+// User is someone allowed to sign in, identified by the provider they
+// sign in with and their login there.
+type User struct {
+	ID       bson.ObjectId `json:"id" bson:"_id"`
+	Provider string        `json:"provider"`
+	Login    string        `json:"login"`
+	Role     string        `json:"role"`
+	Added    time.Time     `json:"added"`
+	AddedBy  string        `json:"addedby"`
+}
+
+// NewUser is the JSON accepted when adding a user.
+type NewUser struct {
+	Provider string `json:"provider"`
+	Login    string `json:"login"`
+	Role     string `json:"role"`
+}
+
+// UserUpdates reflects the fields of a user that are mutable.
+type UserUpdates struct {
+	Role string `json:"role"`
+}
+
+// AccessRule admits every member of a GitHub organization, or of a
+// single team within it when Team is set, with the given role.
+type AccessRule struct {
+	ID      bson.ObjectId `json:"id" bson:"_id"`
+	Org     string        `json:"org"`
+	Team    string        `json:"team"`
+	Role    string        `json:"role"`
+	Added   time.Time     `json:"added"`
+	AddedBy string        `json:"addedby"`
+}
+
+// NewAccessRule is the JSON accepted when adding a rule.
+type NewAccessRule struct {
+	Org  string `json:"org"`
+	Team string `json:"team"`
+	Role string `json:"role"`
+}
+
+// ValidRole reports whether role is one we know about.
+func ValidRole(role string) bool {
+	return role == RoleAdmin || role == RoleWriter
+}
+
+// ToUser validates nu and returns the User to store for it.
+func (nu *NewUser) ToUser(addedBy string) (*User, error) {
+	problems := &ValidationError{}
+	if len(nu.Provider) == 0 {
+		problems.Add("provider", "is required")
+	}
+	if len(nu.Login) == 0 {
+		problems.Add("login", "is required")
+	}
+	if len(nu.Role) == 0 {
+		nu.Role = RoleWriter
+	}
+	if !ValidRole(nu.Role) {
+		problems.Add("role", fmt.Sprintf("unknown role %q", nu.Role))
+	}
+	if err := problems.OrNil(); err != nil {
+		return nil, err
+	}
+	return &User{
+		ID:       bson.NewObjectId(),
+		Provider: nu.Provider,
+		Login:    nu.Login,
+		Role:     nu.Role,
+		Added:    time.Now(),
+		AddedBy:  addedBy,
+	}, nil
+}
+
+// ToAccessRule validates nr and returns the AccessRule to store for it.
+func (nr *NewAccessRule) ToAccessRule(addedBy string) (*AccessRule, error) {
+	problems := &ValidationError{}
+	if len(nr.Org) == 0 {
+		problems.Add("org", "is required")
+	}
+	if len(nr.Role) == 0 {
+		nr.Role = RoleWriter
+	}
+	if !ValidRole(nr.Role) {
+		problems.Add("role", fmt.Sprintf("unknown role %q", nr.Role))
+	}
+	if err := problems.OrNil(); err != nil {
+		return nil, err
+	}
+	return &AccessRule{
+		ID:      bson.NewObjectId(),
+		Org:     nr.Org,
+		Team:    nr.Team,
+		Role:    nr.Role,
+		Added:   time.Now(),
+		AddedBy: addedBy,
+	}, nil
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Events webhooks can subscribe to.
+const (
+	WebhookPostCreated     = "post.created"
+	WebhookPostUpdated     = "post.updated"
+	WebhookPostPublished   = "post.published"
+	WebhookPostUnpublished = "post.unpublished"
+	WebhookPostDeleted     = "post.deleted"
+)
+
+// Statuses of a webhook delivery.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is a URL we POST events to. Payloads are signed with Secret
+// so the receiver can tell they came from us.
+type Webhook struct {
+	ID      bson.ObjectId `json:"id" bson:"_id"`
+	URL     string        `json:"url"`
+	Events  []string      `json:"events"`
+	Secret  string        `json:"secret,omitempty"`
+	Added   time.Time     `json:"added"`
+	AddedBy string        `json:"addedby"`
+}
+
+// NewWebhook is the JSON accepted when adding a webhook. A secret is
+// generated when none is given.
+type NewWebhook struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+// WebhookDelivery is one event queued for, or delivered to, a webhook.
+type WebhookDelivery struct {
+	ID        bson.ObjectId `json:"id" bson:"_id"`
+	WebhookID bson.ObjectId `json:"webhook" bson:"webhook"`
+	Event     string        `json:"event"`
+	// Payload is the JSON body sent, kept so redeliveries are
+	// identical to the first attempt
+	Payload     string             `json:"payload"`
+	Status      string             `json:"status"`
+	Created     time.Time          `json:"created"`
+	NextAttempt time.Time          `json:"nextAttempt" bson:"nextattempt"`
+	Attempts    []*DeliveryAttempt `json:"attempts"`
+}
+
+// DeliveryAttempt is the outcome of one try at a delivery.
+type DeliveryAttempt struct {
+	Time         time.Time `json:"time"`
+	ResponseCode int       `json:"responseCode,omitempty" bson:"responsecode,omitempty"`
+	Error        string    `json:"error,omitempty" bson:"error,omitempty"`
+	DurationMs   float64   `json:"durationMs" bson:"durationms"`
+}
+
+// ValidWebhookEvent reports whether event is one webhooks can
+// subscribe to.
+func ValidWebhookEvent(event string) bool {
+	switch event {
+	case WebhookPostCreated, WebhookPostUpdated, WebhookPostPublished, WebhookPostUnpublished, WebhookPostDeleted:
+		return true
+	}
+	return false
+}
+
+// ToWebhook validates nw and returns the Webhook to store for it.
+func (nw *NewWebhook) ToWebhook(addedBy string) (*Webhook, error) {
+	problems := &ValidationError{}
+	if parsed, err := url.Parse(nw.URL); err != nil || !parsed.IsAbs() || (parsed.Scheme != "https" && parsed.Scheme != "http") {
+		problems.Add("url", "must be an absolute http or https URL")
+	}
+	if len(nw.Events) == 0 {
+		problems.Add("events", "is required")
+	}
+	for _, event := range nw.Events {
+		if !ValidWebhookEvent(event) {
+			problems.Add("events", fmt.Sprintf("unknown event %q", event))
+		}
+	}
+	if len(nw.Secret) == 0 {
+		nw.Secret = newWebhookSecret()
+	} else if len(nw.Secret) < 16 {
+		problems.Add("secret", "must be at least 16 characters")
+	}
+	if err := problems.OrNil(); err != nil {
+		return nil, err
+	}
+	return &Webhook{
+		ID:      bson.NewObjectId(),
+		URL:     nw.URL,
+		Events:  nw.Events,
+		Secret:  nw.Secret,
+		Added:   time.Now(),
+		AddedBy: addedBy,
+	}, nil
+}
+
+// newWebhookSecret returns a random secret to sign payloads with.
+func newWebhookSecret() string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		panic("error generating random bytes")
+	}
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,291 @@
+// Package openapi describes the routes of the API in an OpenAPI 3
+// document as they are registered, generating the schemas of request
+// and response bodies from the Go types handlers decode and encode, and
+// checks request bodies against them before handlers see them.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/KyleWS/blog-api/api-server/apierrors"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3gen"
+)
+
+// Who may call an operation.
+const (
+	// AuthNone operations are open to anyone
+	AuthNone = ""
+	// AuthOptional operations are open to anyone but show signed in
+	// users more, such as drafts
+	AuthOptional = "optional"
+	// AuthUser operations need a session
+	AuthUser = "user"
+	// AuthAdmin operations need an admin's session
+	AuthAdmin = "admin"
+)
+
+// security schemes, a session ID is sent in the Authorization header or
+// the session cookie
+const (
+	schemeBearer = "bearerAuth"
+	schemeCookie = "cookieAuth"
+	errorSchema  = "Error"
+)
+
+const jsonMediaType = "application/json"
+
+var pathParam = regexp.MustCompile(`{([^}.]+)(\.\.\.)?}`)
+
+// Parameter is a query parameter an operation accepts.
+type Parameter struct {
+	Name        string
+	Description string
+}
+
+// Operation describes what a route does and the bodies it takes and
+// returns.
+type Operation struct {
+	Summary string
+	// Auth is one of the Auth constants
+	Auth  string
+	Query []Parameter
+	// Request is a value of the type the request body is decoded into,
+	// nil when there is no body. Bodies are checked against its schema.
+	Request interface{}
+	// Requests are the bodies taken in other media types than JSON,
+	// bodies sent as one of them are checked against its schema instead
+	Requests map[string]interface{}
+	// Status is the status of a successful response, 200 when unset
+	Status int
+	// Response is a value of the type of a successful response body,
+	// nil when there is none
+	Response interface{}
+	// Stream marks responses sent as Server-Sent Events
+	Stream     bool
+	Deprecated bool
+}
+
+// API registers routes on a mux while describing them.
+type API struct {
+	// MaxBody caps the request bodies read to be checked
+	MaxBody int64
+	// Authorized reports whether r may call an operation needing auth,
+	// AuthUser or AuthAdmin. Bodies of requests it refuses are not
+	// checked so their handler answers 401 or 403 rather than 400. Nil
+	// lets every request through.
+	Authorized func(r *http.Request, auth string) bool
+
+	mux *http.ServeMux
+	doc *openapi3.T
+}
+
+// New returns an API registering routes on mux, describing them in a
+// document with the given title and version.
+func New(mux *http.ServeMux, title string, version string) *API {
+	doc := &openapi3.T{
+		OpenAPI: "3.0.3",
+		Info: &openapi3.Info{
+			Title:   title,
+			Version: version,
+		},
+		Paths: openapi3.NewPaths(),
+		Components: &openapi3.Components{
+			Schemas: openapi3.Schemas{},
+			SecuritySchemes: openapi3.SecuritySchemes{
+				schemeBearer: &openapi3.SecuritySchemeRef{Value: &openapi3.SecurityScheme{
+					Type:        "http",
+					Scheme:      "bearer",
+					Description: "the access token returned on sign in",
+				}},
+				schemeCookie: &openapi3.SecuritySchemeRef{Value: &openapi3.SecurityScheme{
+					Type:        "apiKey",
+					In:          "cookie",
+					Name:        "blogapi_session",
+					Description: "set on sign in, unsafe methods must also send X-CSRF-Token",
+				}},
+			},
+		},
+	}
+	doc.Components.Schemas[errorSchema] = mustSchema(apierrors.Envelope{})
+	return &API{
+		MaxBody: 1 << 20,
+		mux:     mux,
+		doc:     doc,
+	}
+}
+
+// HandleFunc registers handler for pattern, like http.ServeMux, and
+// describes it with op.
+func (api *API) HandleFunc(pattern string, handler http.HandlerFunc, op *Operation) {
+	api.Handle(pattern, handler, op)
+}
+
+// Handle registers handler for pattern, like http.ServeMux, and
+// describes it with op. When op has a request body, bodies not matching
+// its schema are refused before handler is called.
+func (api *API) Handle(pattern string, handler http.Handler, op *Operation) {
+	schemas := api.Describe(pattern, op)
+	if schemas != nil {
+		handler = api.validateBody(schemas, op.Auth, handler)
+	}
+	api.mux.Handle(pattern, handler)
+}
+
+// Describe adds the route for pattern to the document without
+// registering it, for routes served by another mux. It returns the
+// schemas of the request body by media type, if there is one.
+func (api *API) Describe(pattern string, op *Operation) map[string]*openapi3.Schema {
+	method, path, found := strings.Cut(pattern, " ")
+	if !found {
+		// patterns without a method match them all, describe the one
+		// clients use
+		method, path = http.MethodGet, pattern
+	}
+	// the document has no room for the host or wildcards that match
+	// the rest of the path
+	path = pathParam.ReplaceAllString(path[strings.Index(path, "/"):], "{$1}")
+
+	operation := &openapi3.Operation{
+		Summary:    op.Summary,
+		Deprecated: op.Deprecated,
+		Responses:  openapi3.NewResponses(),
+	}
+	for _, match := range pathParam.FindAllStringSubmatch(path, -1) {
+		operation.AddParameter(openapi3.NewPathParameter(match[1]).WithSchema(openapi3.NewStringSchema()))
+	}
+	for _, param := range op.Query {
+		operation.AddParameter(openapi3.NewQueryParameter(param.Name).
+			WithDescription(param.Description).
+			WithSchema(openapi3.NewStringSchema()))
+	}
+
+	var requestSchemas map[string]*openapi3.Schema
+	if op.Request != nil {
+		ref := api.schemaRef(op.Request)
+		requestSchemas = map[string]*openapi3.Schema{jsonMediaType: ref.Value}
+		body := openapi3.NewRequestBody().
+			WithRequired(true).
+			WithJSONSchemaRef(ref)
+		for mediaType, request := range op.Requests {
+			ref := api.schemaRef(request)
+			requestSchemas[mediaType] = ref.Value
+			body.Content[mediaType] = openapi3.NewMediaType().WithSchemaRef(ref)
+		}
+		operation.RequestBody = &openapi3.RequestBodyRef{Value: body}
+		operation.AddResponse(http.StatusBadRequest, api.errorResponse("the request is malformed"))
+		operation.AddResponse(http.StatusRequestEntityTooLarge, api.errorResponse("the request body is too large"))
+		if len(op.Requests) > 0 {
+			operation.AddResponse(http.StatusUnsupportedMediaType, api.errorResponse("the request body is in a media type not taken"))
+		}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := openapi3.NewResponse().WithDescription(http.StatusText(status))
+	switch {
+	case op.Stream:
+		success.Content = openapi3.NewContentWithSchema(openapi3.NewStringSchema(), []string{"text/event-stream"})
+	case op.Response != nil:
+		success.Content = openapi3.NewContentWithJSONSchemaRef(api.schemaRef(op.Response))
+	}
+	operation.AddResponse(status, success)
+	if len(operation.Parameters) > 0 {
+		operation.AddResponse(http.StatusNotFound, api.errorResponse("there is nothing with that ID"))
+	}
+
+	bearer := openapi3.NewSecurityRequirement().Authenticate(schemeBearer)
+	cookie := openapi3.NewSecurityRequirement().Authenticate(schemeCookie)
+	switch op.Auth {
+	case AuthOptional:
+		operation.Security = openapi3.NewSecurityRequirements().With(openapi3.NewSecurityRequirement()).With(bearer).With(cookie)
+	case AuthUser, AuthAdmin:
+		operation.Security = openapi3.NewSecurityRequirements().With(bearer).With(cookie)
+		operation.AddResponse(http.StatusUnauthorized, api.errorResponse("no session was sent or it has expired"))
+		if op.Auth == AuthAdmin {
+			operation.AddResponse(http.StatusForbidden, api.errorResponse("the session is not an admin's"))
+		}
+	}
+	operation.Responses.Set("default", &openapi3.ResponseRef{Value: api.errorResponse("something went wrong")})
+
+	api.doc.AddOperation(path, method, operation)
+	return requestSchemas
+}
+
+// Handler serves the document as JSON.
+func (api *API) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(api.doc)
+	}
+}
+
+// schemaRef returns a reference to the schema of the type of value,
+// adding named struct types to the document's components.
+func (api *API) schemaRef(value interface{}) *openapi3.SchemaRef {
+	name := schemaName(value)
+	if len(name) == 0 {
+		return mustSchema(value)
+	}
+	component, found := api.doc.Components.Schemas[name]
+	if !found {
+		component = mustSchema(value)
+		api.doc.Components.Schemas[name] = component
+	}
+	return openapi3.NewSchemaRef("#/components/schemas/"+name, component.Value)
+}
+
+// schemaName names the component for the type of value, empty for
+// anonymous types and maps. Slices are named after their elements.
+func schemaName(value interface{}) string {
+	t := reflect.TypeOf(value)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Slice {
+		elem := t.Elem()
+		for elem.Kind() == reflect.Ptr {
+			elem = elem.Elem()
+		}
+		if len(elem.Name()) > 0 {
+			return elem.Name() + "List"
+		}
+	}
+	if t.Kind() != reflect.Struct {
+		return ""
+	}
+	return t.Name()
+}
+
+// mustSchema generates the schema of the type of value. The types are
+// fixed when the program is built, so failing is a bug.
+func mustSchema(value interface{}) *openapi3.SchemaRef {
+	ref, err := openapi3gen.NewSchemaRefForValue(value, nil, openapi3gen.UseAllExportedFields(), openapi3gen.SchemaCustomizer(nullable))
+	if err != nil {
+		panic(fmt.Sprintf("openapi: error generating schema for %T: %v", value, err))
+	}
+	return ref
+}
+
+// nullable marks the schemas of slices, maps and pointers as nullable,
+// encoding/json reads and writes nil ones as null.
+func nullable(name string, t reflect.Type, tag reflect.StructTag, schema *openapi3.Schema) error {
+	switch t.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Ptr, reflect.Interface:
+		schema.Nullable = true
+	}
+	return nil
+}
+
+func (api *API) errorResponse(description string) *openapi3.Response {
+	return openapi3.NewResponse().
+		WithDescription(description).
+		WithJSONSchemaRef(openapi3.NewSchemaRef("#/components/schemas/"+errorSchema, api.doc.Components.Schemas[errorSchema].Value))
+}
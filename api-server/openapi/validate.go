@@ -0,0 +1,88 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/KyleWS/blog-api/api-server/apierrors"
+	"github.com/KyleWS/blog-api/api-server/models"
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+// validateBody refuses requests whose body does not match the schema
+// for its media type with a 400 listing every field that is wrong, then
+// hands the body on to handler. Bodies in media types without a schema
+// are checked as JSON, handler decides whether it takes them. Callers
+// not allowed to use an operation needing auth go straight to handler
+// to be refused.
+func (api *API) validateBody(schemas map[string]*openapi3.Schema, auth string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (auth == AuthUser || auth == AuthAdmin) && api.Authorized != nil && !api.Authorized(r, auth) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		schema := schemas[jsonMediaType]
+		if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && schemas[mediaType] != nil {
+			schema = schemas[mediaType]
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, api.MaxBody))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			apierrors.Write(w, r, http.StatusRequestEntityTooLarge, apierrors.CodeTooLarge, fmt.Sprintf("error request body is over %d bytes", tooLarge.Limit), nil)
+			return
+		}
+		if err != nil {
+			apierrors.Write(w, r, http.StatusBadRequest, apierrors.CodeInvalidRequest, fmt.Sprintf("error reading request body: %v", err), nil)
+			return
+		}
+		var decoded interface{}
+		if err := json.Unmarshal(body, &decoded); err != nil {
+			apierrors.Write(w, r, http.StatusBadRequest, apierrors.CodeInvalidRequest, fmt.Sprintf("error decoding received json: %v", err), nil)
+			return
+		}
+		if problems := check(schema, decoded); problems != nil {
+			apierrors.Write(w, r, http.StatusBadRequest, apierrors.CodeInvalidRequest, "error request body does not match its schema", problems.Fields)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// check returns what is wrong with value according to schema, nil
+// when it matches.
+func check(schema *openapi3.Schema, value interface{}) *models.ValidationError {
+	err := schema.VisitJSON(value, openapi3.MultiErrors())
+	if err == nil {
+		return nil
+	}
+	problems := &models.ValidationError{}
+	var multi openapi3.MultiError
+	if !errors.As(err, &multi) {
+		multi = openapi3.MultiError{err}
+	}
+	for _, err := range multi {
+		var schemaErr *openapi3.SchemaError
+		if !errors.As(err, &schemaErr) {
+			problems.Add("body", err.Error())
+			continue
+		}
+		field := strings.Join(schemaErr.JSONPointer(), ".")
+		if len(field) == 0 {
+			field = "body"
+		}
+		reason := schemaErr.Reason
+		if schemaErr.SchemaField == "format" {
+			// the reason spells out the pattern of the format
+			reason = fmt.Sprintf("value must be a %s", schemaErr.Schema.Format)
+		}
+		problems.Add(field, reason)
+	}
+	return problems
+}
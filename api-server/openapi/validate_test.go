@@ -0,0 +1,58 @@
+package openapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateBodyAfterAuth(t *testing.T) {
+	type note struct {
+		Title string `json:"title"`
+	}
+	mux := http.NewServeMux()
+	api := New(mux, "test", "1")
+	api.Authorized = func(r *http.Request, auth string) bool {
+		return r.Header.Get("Authorization") == auth
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Header.Get("Authorization") == "":
+			w.WriteHeader(http.StatusUnauthorized)
+		case strings.HasPrefix(r.URL.Path, "/admin/") && r.Header.Get("Authorization") != AuthAdmin:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}
+	api.HandleFunc("POST /notes", handler, &Operation{Auth: AuthUser, Request: note{}})
+	api.HandleFunc("POST /admin/notes", handler, &Operation{Auth: AuthAdmin, Request: note{}})
+	api.HandleFunc("POST /public/notes", handler, &Operation{Request: note{}})
+	cases := []struct {
+		name          string
+		path          string
+		authorization string
+		body          string
+		status        int
+	}{
+		{name: "no session", path: "/notes", body: `{"title": 7}`, status: http.StatusUnauthorized},
+		{name: "signed in", path: "/notes", authorization: AuthUser, body: `{"title": 7}`, status: http.StatusBadRequest},
+		{name: "valid body", path: "/notes", authorization: AuthUser, body: `{"title": "Hi"}`, status: http.StatusOK},
+		{name: "not an admin", path: "/admin/notes", authorization: AuthUser, body: `{"title": 7}`, status: http.StatusForbidden},
+		{name: "admin", path: "/admin/notes", authorization: AuthAdmin, body: `{"title": 7}`, status: http.StatusBadRequest},
+		{name: "open to anyone", path: "/public/notes", body: `{"title": 7}`, status: http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, c.path, strings.NewReader(c.body))
+			r.Header.Set("Content-Type", jsonMediaType)
+			if len(c.authorization) > 0 {
+				r.Header.Set("Authorization", c.authorization)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			if w.Code != c.status {
+				t.Errorf("got status %d, want %d: %s", w.Code, c.status, w.Body)
+			}
+		})
+	}
+}
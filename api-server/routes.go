@@ -0,0 +1,220 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/KyleWS/blog-api/api-server/handlers"
+	"github.com/KyleWS/blog-api/api-server/models"
+	"github.com/KyleWS/blog-api/api-server/openapi"
+	"github.com/KyleWS/blog-api/api-server/sessions"
+)
+
+// postPatches are the patches updating a post takes
+var postPatches = map[string]interface{}{
+	handlers.MediaMergePatch: models.TextPostUpdates{},
+	handlers.MediaJSONPatch:  []*handlers.JSONPatchOperation{},
+}
+
+// registerRoutes adds the routes of the API to api, describing each in
+// the OpenAPI document.
+func registerRoutes(api *openapi.API, reqCtx *handlers.ReqCtx, authCtx *sessions.AuthContext, graphQL http.Handler) {
+	api.HandleFunc(apiSignIn, authCtx.OAuthSignInHandler, &openapi.Operation{
+		Summary: "Redirect to the identity provider to sign in",
+		Query: []openapi.Parameter{
+			{Name: "provider", Description: "identity provider to sign in with"},
+			{Name: "return_to", Description: "frontend page to send the user back to once signed in"},
+		},
+		Status: http.StatusSeeOther,
+	})
+	api.HandleFunc(apiReply, authCtx.OAuthReplyHandler, &openapi.Operation{
+		Summary:  "Finish signing in once the identity provider sends the user back",
+		Response: sessions.SignInResponse{},
+	})
+
+	api.HandleFunc("GET /v1/posts", reqCtx.ListPostsHandler, &openapi.Operation{
+		Summary:  "List posts without their bodies, drafts only for signed in users",
+		Auth:     openapi.AuthOptional,
+		Response: []*models.PostShort{},
+	})
+	api.HandleFunc("POST /v1/posts", reqCtx.CreatePostHandler, &openapi.Operation{
+		Summary:  "Create a post",
+		Auth:     openapi.AuthUser,
+		Request:  models.UserTextPost{},
+		Status:   http.StatusCreated,
+		Response: models.TextPost{},
+	})
+	api.HandleFunc("POST /v1/posts/bulk", reqCtx.BulkPostsHandler, &openapi.Operation{
+		Summary:  "Publish, unpublish, retag, reassign or trash many posts at once",
+		Auth:     openapi.AuthUser,
+		Request:  models.BulkPostRequest{},
+		Response: handlers.BulkResult{},
+	})
+	api.HandleFunc("GET /v1/posts/{id}", reqCtx.GetPostHandler, &openapi.Operation{
+		Summary:  "Get a post",
+		Response: models.TextPost{},
+	})
+	api.HandleFunc("PATCH /v1/posts/{id}", reqCtx.UpdatePostHandler, &openapi.Operation{
+		Summary:  "Update a post with a merge patch, plain JSON is read as one, or a JSON Patch",
+		Auth:     openapi.AuthUser,
+		Request:  models.TextPostUpdates{},
+		Requests: postPatches,
+		Response: models.TextPost{},
+	})
+	api.HandleFunc("DELETE /v1/posts/{id}", reqCtx.DeletePostHandler, &openapi.Operation{
+		Summary: "Delete a post",
+		Auth:    openapi.AuthUser,
+	})
+	api.HandleFunc("POST /v1/posts/{id}/restore", reqCtx.RestorePostHandler, &openapi.Operation{
+		Summary:  "Restore a deleted post as it was when deleted",
+		Auth:     openapi.AuthUser,
+		Status:   http.StatusCreated,
+		Response: models.TextPost{},
+	})
+	api.HandleFunc("GET /v1/events", reqCtx.EventsHandler, &openapi.Operation{
+		Summary: "Stream changes to every post",
+		Auth:    openapi.AuthUser,
+		Stream:  true,
+	})
+	api.HandleFunc("GET /v1/events/public", reqCtx.PublicEventsHandler, &openapi.Operation{
+		Summary: "Stream changes to published posts",
+		Stream:  true,
+	})
+	api.HandleFunc("GET /events", reqCtx.EventsHandler, &openapi.Operation{
+		Summary: "Stream changes to every post, the same as GET /v1/events",
+		Auth:    openapi.AuthUser,
+		Stream:  true,
+	})
+	api.HandleFunc("GET /events/public", reqCtx.PublicEventsHandler, &openapi.Operation{
+		Summary: "Stream changes to published posts, the same as GET /v1/events/public",
+		Stream:  true,
+	})
+	api.Handle("POST /graphql", graphQL, &openapi.Operation{
+		Summary:  "Query posts, tags and authors with GraphQL, mutations need a session",
+		Auth:     openapi.AuthOptional,
+		Request:  handlers.GraphQLParams{},
+		Response: map[string]interface{}{},
+	})
+
+	api.HandleFunc("GET /v1/admin/users", reqCtx.ListUsersHandler, &openapi.Operation{
+		Summary:  "List the users allowed to sign in",
+		Auth:     openapi.AuthAdmin,
+		Response: []*models.User{},
+	})
+	api.HandleFunc("POST /v1/admin/users", reqCtx.CreateUserHandler, &openapi.Operation{
+		Summary:  "Allow a user to sign in",
+		Auth:     openapi.AuthAdmin,
+		Request:  models.NewUser{},
+		Status:   http.StatusCreated,
+		Response: models.User{},
+	})
+	api.HandleFunc("PATCH /v1/admin/users/{provider}/{login}", reqCtx.UpdateUserHandler, &openapi.Operation{
+		Summary:  "Change a user's role",
+		Auth:     openapi.AuthAdmin,
+		Request:  models.UserUpdates{},
+		Response: models.User{},
+	})
+	api.HandleFunc("DELETE /v1/admin/users/{provider}/{login}", reqCtx.DeleteUserHandler, &openapi.Operation{
+		Summary: "Stop a user signing in and end their sessions",
+		Auth:    openapi.AuthAdmin,
+	})
+	api.HandleFunc("GET /v1/admin/rules", reqCtx.ListRulesHandler, &openapi.Operation{
+		Summary:  "List the organization access rules",
+		Auth:     openapi.AuthAdmin,
+		Response: []*models.AccessRule{},
+	})
+	api.HandleFunc("POST /v1/admin/rules", reqCtx.CreateRuleHandler, &openapi.Operation{
+		Summary:  "Let the members of an organization or team sign in",
+		Auth:     openapi.AuthAdmin,
+		Request:  models.NewAccessRule{},
+		Status:   http.StatusCreated,
+		Response: models.AccessRule{},
+	})
+	api.HandleFunc("DELETE /v1/admin/rules/{id}", reqCtx.DeleteRuleHandler, &openapi.Operation{
+		Summary: "Remove an organization access rule",
+		Auth:    openapi.AuthAdmin,
+	})
+	auditQuery := []openapi.Parameter{
+		{Name: "actor", Description: "only events by this user"},
+		{Name: "post", Description: "only events about this post ID"},
+		{Name: "since", Description: "only events from this RFC 3339 time on"},
+		{Name: "limit", Description: "most events returned"},
+	}
+	api.HandleFunc("GET /v1/admin/audit", reqCtx.AuditHandler, &openapi.Operation{
+		Summary:  "List audit events, newest first",
+		Auth:     openapi.AuthAdmin,
+		Query:    auditQuery,
+		Response: []*models.AuditEvent{},
+	})
+	api.HandleFunc("GET /audit", reqCtx.AuditHandler, &openapi.Operation{
+		Summary:  "List audit events, newest first, the same as GET /v1/admin/audit",
+		Auth:     openapi.AuthAdmin,
+		Query:    auditQuery,
+		Response: []*models.AuditEvent{},
+	})
+	api.HandleFunc("GET /v1/admin/webhooks", reqCtx.ListWebhooksHandler, &openapi.Operation{
+		Summary:  "List webhooks without their secrets",
+		Auth:     openapi.AuthAdmin,
+		Response: []*models.Webhook{},
+	})
+	api.HandleFunc("POST /v1/admin/webhooks", reqCtx.CreateWebhookHandler, &openapi.Operation{
+		Summary:  "Add a webhook, the response is the only time its secret is shown",
+		Auth:     openapi.AuthAdmin,
+		Request:  models.NewWebhook{},
+		Status:   http.StatusCreated,
+		Response: models.Webhook{},
+	})
+	api.HandleFunc("DELETE /v1/admin/webhooks/{id}", reqCtx.DeleteWebhookHandler, &openapi.Operation{
+		Summary: "Remove a webhook",
+		Auth:    openapi.AuthAdmin,
+	})
+	api.HandleFunc("GET /v1/admin/webhooks/{id}/deliveries", reqCtx.ListDeliveriesHandler, &openapi.Operation{
+		Summary:  "List a webhook's newest deliveries and their attempts",
+		Auth:     openapi.AuthAdmin,
+		Query:    []openapi.Parameter{{Name: "limit", Description: "most deliveries returned"}},
+		Response: []*models.WebhookDelivery{},
+	})
+	api.HandleFunc("POST /v1/admin/webhooks/{id}/deliveries/{delivery}/redeliver", reqCtx.RedeliverHandler, &openapi.Operation{
+		Summary: "Send a delivery again",
+		Auth:    openapi.AuthAdmin,
+		Status:  http.StatusAccepted,
+	})
+
+	// legacy routes kept for clients written before /v1
+	api.HandleFunc("GET /all", handlers.Deprecated("/v1/posts", reqCtx.ListPostsHandler), &openapi.Operation{
+		Summary:    "Use GET /v1/posts",
+		Auth:       openapi.AuthOptional,
+		Response:   []*models.PostShort{},
+		Deprecated: true,
+	})
+	api.HandleFunc("POST /post/", handlers.Deprecated("/v1/posts", reqCtx.CreatePostHandler), &openapi.Operation{
+		Summary:    "Use POST /v1/posts",
+		Auth:       openapi.AuthUser,
+		Request:    models.UserTextPost{},
+		Status:     http.StatusCreated,
+		Response:   models.TextPost{},
+		Deprecated: true,
+	})
+	api.HandleFunc("GET /post/{id}", handlers.Deprecated("/v1/posts/{id}", reqCtx.GetPostHandler), &openapi.Operation{
+		Summary:    "Use GET /v1/posts/{id}",
+		Response:   models.TextPost{},
+		Deprecated: true,
+	})
+	api.HandleFunc("PATCH /post/{id}", handlers.Deprecated("/v1/posts/{id}", reqCtx.UpdatePostHandler), &openapi.Operation{
+		Summary:    "Use PATCH /v1/posts/{id}",
+		Auth:       openapi.AuthUser,
+		Request:    models.TextPostUpdates{},
+		Requests:   postPatches,
+		Response:   models.TextPost{},
+		Deprecated: true,
+	})
+	api.HandleFunc("DELETE /post/{id}", handlers.Deprecated("/v1/posts/{id}", reqCtx.DeletePostHandler), &openapi.Operation{
+		Summary:    "Use DELETE /v1/posts/{id}",
+		Auth:       openapi.AuthUser,
+		Deprecated: true,
+	})
+
+	api.HandleFunc("GET /openapi.json", api.Handler(), &openapi.Operation{
+		Summary:  "This document",
+		Response: map[string]interface{}{},
+	})
+}
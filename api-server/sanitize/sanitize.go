@@ -0,0 +1,144 @@
+// Package sanitize strips the HTML in post bodies down to an allowlist,
+// so frontends can render bodies without running what authors pasted,
+// and reports what it took out.
+package sanitize
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"golang.org/x/net/html"
+)
+
+// Removal is an element or attribute taken out of a body and how many
+// times it was.
+type Removal struct {
+	Element string `json:"element"`
+	// Attribute is empty when the whole element went
+	Attribute string `json:"attribute,omitempty"`
+	Count     int    `json:"count"`
+}
+
+// Sanitizer cleans bodies with an allowlist policy. It is safe for
+// concurrent use.
+type Sanitizer struct {
+	policy *bluemonday.Policy
+}
+
+// New returns a Sanitizer allowing the markup of user generated content
+// with links to urlSchemes and iframes only from iframeHosts.
+func New(urlSchemes []string, iframeHosts []string) *Sanitizer {
+	policy := bluemonday.UGCPolicy()
+	policy.AllowURLSchemes(urlSchemes...)
+	policy.RequireParseableURLs(true)
+	if len(iframeHosts) > 0 {
+		hosts := make([]string, 0, len(iframeHosts))
+		for _, host := range iframeHosts {
+			hosts = append(hosts, regexp.QuoteMeta(strings.ToLower(host)))
+		}
+		// an iframe left without src has no attributes, so it is
+		// dropped along with it
+		src := regexp.MustCompile(fmt.Sprintf(`(?i)^https://(%s)(/|$)`, strings.Join(hosts, "|")))
+		policy.AllowAttrs("src").Matching(src).OnElements("iframe")
+	}
+	return &Sanitizer{policy: policy}
+}
+
+// Sanitize returns body without anything outside the allowlist and what
+// was removed. Any body holding a < is returned as the policy writes
+// it, which escapes the <, > and & left in its text, only bodies
+// without markup come back as they were.
+func (s *Sanitizer) Sanitize(body string) (string, []*Removal) {
+	if !strings.Contains(body, "<") {
+		return body, nil
+	}
+	clean := s.policy.Sanitize(body)
+	removed := diff(count(body), count(clean))
+	if len(removed) == 0 {
+		removed = nil
+	}
+	return clean, removed
+}
+
+// markup counts the elements and attributes in a body, attributes are
+// keyed by element and name. Comments and doctypes count as elements
+// named as x/net/html names their nodes.
+type markup struct {
+	elements   map[string]int
+	attributes map[[2]string]int
+}
+
+func count(body string) *markup {
+	found := &markup{
+		elements:   map[string]int{},
+		attributes: map[[2]string]int{},
+	}
+	tokens := html.NewTokenizer(strings.NewReader(body))
+	for {
+		switch tokens.Next() {
+		case html.ErrorToken:
+			// a tag left open at the end is not a token, but browsers
+			// may still close it once the body is wrapped in a page
+			if name := unterminatedTag(tokens.Raw()); len(name) > 0 {
+				found.elements[name]++
+			}
+			return found
+		case html.CommentToken:
+			found.elements["#comment"]++
+		case html.DoctypeToken:
+			found.elements["#doctype"]++
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokens.Token()
+			found.elements[token.Data]++
+			for _, attr := range token.Attr {
+				found.attributes[[2]string{token.Data, attr.Key}]++
+			}
+		}
+	}
+}
+
+// unterminatedTag returns the name of the tag raw starts, lower cased,
+// empty if it does not start one.
+func unterminatedTag(raw []byte) string {
+	if len(raw) < 2 || raw[0] != '<' {
+		return ""
+	}
+	name := raw[1:]
+	if name[0] == '/' {
+		name = name[1:]
+	}
+	end := 0
+	for end < len(name) && (name[end] >= 'a' && name[end] <= 'z' || name[end] >= 'A' && name[end] <= 'Z' || end > 0 && name[end] >= '0' && name[end] <= '9') {
+		end++
+	}
+	return strings.ToLower(string(name[:end]))
+}
+
+// diff lists what in before is missing from after. Attributes are not
+// listed when every element they were on went.
+func diff(before *markup, after *markup) []*Removal {
+	removed := []*Removal{}
+	for element, n := range before.elements {
+		if after.elements[element] < n {
+			removed = append(removed, &Removal{Element: element, Count: n - after.elements[element]})
+		}
+	}
+	for key, n := range before.attributes {
+		if after.elements[key[0]] == 0 {
+			continue
+		}
+		if after.attributes[key] < n {
+			removed = append(removed, &Removal{Element: key[0], Attribute: key[1], Count: n - after.attributes[key]})
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool {
+		if removed[i].Element != removed[j].Element {
+			return removed[i].Element < removed[j].Element
+		}
+		return removed[i].Attribute < removed[j].Attribute
+	})
+	return removed
+}
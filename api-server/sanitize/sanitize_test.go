@@ -0,0 +1,134 @@
+package sanitize
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSanitize(t *testing.T) {
+	s := New([]string{"http", "https", "mailto"}, []string{"www.youtube-nocookie.com"})
+	cases := []struct {
+		name    string
+		body    string
+		want    string
+		removed []*Removal
+	}{
+		{
+			name: "markdown without markup is untouched",
+			body: "# Title\n\n`a && b > c`",
+			want: "# Title\n\n`a && b > c`",
+		},
+		{
+			name: "allowed markup is kept and text escaped",
+			body: "<b>bold</b> a < b",
+			want: "<b>bold</b> a &lt; b",
+		},
+		{
+			name:    "script",
+			body:    "<p>hi</p><script>alert(1)</script>",
+			want:    "<p>hi</p>",
+			removed: []*Removal{{Element: "script", Count: 1}},
+		},
+		{
+			name:    "event handler",
+			body:    `<p onclick="alert(1)">hi</p>`,
+			want:    "<p>hi</p>",
+			removed: []*Removal{{Element: "p", Attribute: "onclick", Count: 1}},
+		},
+		{
+			name:    "javascript link",
+			body:    `<a href="javascript:alert(1)">x</a><a href="https://example.com">y</a>`,
+			want:    `x<a href="https://example.com" rel="nofollow">y</a>`,
+			removed: []*Removal{{Element: "a", Count: 1}, {Element: "a", Attribute: "href", Count: 1}},
+		},
+		{
+			name:    "entity encoded javascript link",
+			body:    `<a href="java&#x09;script:alert(1)">x</a>`,
+			want:    "x",
+			removed: []*Removal{{Element: "a", Count: 1}},
+		},
+		{
+			name:    "unterminated tag",
+			body:    "hi <img src=x onerror=alert(1)//",
+			want:    "hi ",
+			removed: []*Removal{{Element: "img", Count: 1}},
+		},
+		{
+			name:    "unterminated tag with no attributes",
+			body:    "hi <script",
+			want:    "hi ",
+			removed: []*Removal{{Element: "script", Count: 1}},
+		},
+		{
+			name:    "unterminated comment",
+			body:    "hi <!-- <script>alert(1)</script>",
+			want:    "hi ",
+			removed: []*Removal{{Element: "#comment", Count: 1}},
+		},
+		{
+			name:    "image error handler",
+			body:    "<img src=x onerror=alert(1)>",
+			want:    `<img src="x">`,
+			removed: []*Removal{{Element: "img", Attribute: "onerror", Count: 1}},
+		},
+		{
+			name:    "svg",
+			body:    "<svg onload=alert(1)></svg>ok",
+			want:    "ok",
+			removed: []*Removal{{Element: "svg", Count: 1}},
+		},
+		{
+			name: "iframe from an allowed host",
+			body: `<iframe src="https://www.youtube-nocookie.com/embed/1"></iframe>`,
+			want: `<iframe src="https://www.youtube-nocookie.com/embed/1"></iframe>`,
+		},
+		{
+			name:    "iframe from another host",
+			body:    `<iframe src="https://evil.example/embed/1"></iframe>`,
+			want:    "",
+			removed: []*Removal{{Element: "iframe", Count: 1}},
+		},
+		{
+			name:    "iframe over http",
+			body:    `<iframe src="http://www.youtube-nocookie.com/embed/1"></iframe>`,
+			want:    "",
+			removed: []*Removal{{Element: "iframe", Count: 1}},
+		},
+		{
+			name:    "iframe host as a prefix",
+			body:    `<iframe src="https://www.youtube-nocookie.com.evil.example/"></iframe>`,
+			want:    "",
+			removed: []*Removal{{Element: "iframe", Count: 1}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, removed := s.Sanitize(c.body)
+			if got != c.want {
+				t.Errorf("Sanitize(%q) = %q, want %q", c.body, got, c.want)
+			}
+			if !reflect.DeepEqual(removed, c.removed) {
+				t.Errorf("Sanitize(%q) removed %s, want %s", c.body, describe(removed), describe(c.removed))
+			}
+		})
+	}
+}
+
+func TestSanitizeIsIdempotent(t *testing.T) {
+	s := New([]string{"http", "https"}, nil)
+	once, _ := s.Sanitize(`<p onclick="x">a < b & c</p><script>y</script>`)
+	twice, removed := s.Sanitize(once)
+	if twice != once || removed != nil {
+		t.Errorf("sanitizing %q again gave %q removing %s", once, twice, describe(removed))
+	}
+}
+
+func describe(removed []*Removal) string {
+	parts := make([]string, 0, len(removed))
+	for _, removal := range removed {
+		parts = append(parts, fmt.Sprintf("%s.%s=%d", removal.Element, removal.Attribute, removal.Count))
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
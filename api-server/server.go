@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/KyleWS/blog-api/api-server/handlers"
+	logrus "github.com/sirupsen/logrus"
+)
+
+// serverTimeouts bound how long a client may take over each part of a
+// request, so slow or idle clients cannot hold connections forever.
+type serverTimeouts struct {
+	ReadHeader time.Duration
+	Read       time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+}
+
+// newServer returns a server for handler listening on addr.
+func newServer(addr string, handler http.Handler, timeouts serverTimeouts) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: timeouts.ReadHeader,
+		ReadTimeout:       timeouts.Read,
+		WriteTimeout:      timeouts.Write,
+		IdleTimeout:       timeouts.Idle,
+		ErrorLog:          log.New(logrus.StandardLogger().WriterLevel(logrus.WarnLevel), "", 0),
+	}
+}
+
+// serve starts server in the background, over TLS unless certFile is
+// empty, and returns a channel receiving the error it stops with.
+// Listening happens before serve returns, so a bad address is
+// reported straight away.
+func serve(server *http.Server, certFile string, keyFile string) (<-chan error, error) {
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return nil, err
+	}
+	stopped := make(chan error, 1)
+	go func() {
+		if len(certFile) > 0 {
+			stopped <- server.ServeTLS(listener, certFile, keyFile)
+		} else {
+			stopped <- server.Serve(listener)
+		}
+	}()
+	return stopped, nil
+}
+
+// shutdownStep is something to close down once we stop serving.
+type shutdownStep struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// waitForShutdown blocks until SIGINT or SIGTERM arrives or the server
+// stops on its own. It then reports draining on /readyz, waits
+// drainDelay for load balancers to notice, and stops servers letting
+// in flight requests finish. Whatever time is left of timeout goes to
+// the remaining steps, in order. It returns the error the server
+// stopped with if it did so on its own.
+func waitForShutdown(stopped <-chan error, health *handlers.Health, drainDelay time.Duration, timeout time.Duration, servers []*http.Server, steps ...shutdownStep) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	var serveErr error
+	select {
+	case err := <-stopped:
+		if !errors.Is(err, http.ErrServerClosed) {
+			serveErr = err
+		}
+		health.SetState(handlers.StateDraining)
+	case sig := <-signals:
+		logrus.WithField("signal", sig.String()).Warn("shutting down")
+		health.SetState(handlers.StateDraining)
+		select {
+		case <-time.After(drainDelay):
+		case sig := <-signals:
+			logrus.WithField("signal", sig.String()).Warn("skipping drain delay")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"addr": server.Addr,
+				"err":  err,
+			}).Error("error waiting for in flight requests")
+			server.Close()
+		}
+	}
+	for _, step := range steps {
+		if err := step.run(ctx); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"step": step.name,
+				"err":  err,
+			}).Error("error shutting down")
+		}
+	}
+	logrus.Warn("shutdown complete")
+	return serveErr
+}
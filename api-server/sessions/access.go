@@ -0,0 +1,70 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+
+	"github.com/KyleWS/blog-api/api-server/logging"
+	"github.com/KyleWS/blog-api/api-server/models"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// AccessStore tells us who is allowed to sign in.
+type AccessStore interface {
+	GetUser(provider string, login string) (*models.User, error)
+
+	AllRules() ([]*models.AccessRule, error)
+}
+
+// MembershipChecker is implemented by providers that can tell whether
+// a user belongs to an organization, or to a team within it.
+type MembershipChecker interface {
+	IsMember(ctx context.Context, token *oauth2.Token, identity *Identity, org string, team string) (bool, error)
+}
+
+// roleFor returns the role identity signs in with, or an empty string
+// when they are not allowed in, and the rule that admitted them if any.
+// Users stored in the access store get their stored role, anyone else
+// is checked against the organization rules if the provider can check
+// memberships.
+func (ctx *AuthContext) roleFor(rctx context.Context, provider Provider, identity *Identity, token *oauth2.Token) (string, bson.ObjectId) {
+	user, err := ctx.Users.GetUser(identity.Provider, identity.Login)
+	if err == nil {
+		return user.Role, ""
+	}
+	if !errors.Is(err, models.ErrNotFound) {
+		logging.FromContext(rctx).WithField("err", err).Warn("error looking up user")
+		return "", ""
+	}
+
+	checker, ok := provider.(MembershipChecker)
+	if !ok {
+		return "", ""
+	}
+	rules, err := ctx.Users.AllRules()
+	if err != nil {
+		logging.FromContext(rctx).WithField("err", err).Warn("error fetching access rules")
+		return "", ""
+	}
+	role, admittedBy := "", bson.ObjectId("")
+	for _, rule := range rules {
+		if role == models.RoleAdmin || role == rule.Role {
+			continue
+		}
+		member, err := checker.IsMember(rctx, token, identity, rule.Org, rule.Team)
+		if err != nil {
+			logging.FromContext(rctx).WithFields(logrus.Fields{
+				"org":  rule.Org,
+				"team": rule.Team,
+				"err":  err,
+			}).Warn("error checking organization membership")
+			continue
+		}
+		if member {
+			role, admittedBy = rule.Role, rule.ID
+		}
+	}
+	return role, admittedBy
+}
@@ -0,0 +1,145 @@
+package sessions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	//githubCurrentUserAPI is the URL for GitHub's current user API
+	githubCurrentUserAPI = "https://api.github.com/user"
+
+	//githubOrgMembershipAPI is the URL format for the current user's
+	//membership of an organization, it requires the read:org scope
+	githubOrgMembershipAPI = "https://api.github.com/user/memberships/orgs/%s"
+
+	//githubTeamMembershipAPI is the URL format for a user's membership
+	//of a team, it requires the read:org scope
+	githubTeamMembershipAPI = "https://api.github.com/orgs/%s/teams/%s/memberships/%s"
+
+	//acceptGitHubV3JSON is the value you should include in
+	//the Accept header when making requests to the GitHub API
+	acceptGitHubV3JSON = "application/vnd.github.v3+json"
+)
+
+// GithubProvider signs users in with their GitHub account.
+type GithubProvider struct {
+	//OauthConfig is the OAuth configuration for GitHub
+	OauthConfig *oauth2.Config
+}
+
+// NewGithubProvider returns a GithubProvider using the given
+// OAuth configuration.
+func NewGithubProvider(config *oauth2.Config) *GithubProvider {
+	return &GithubProvider{
+		OauthConfig: config,
+	}
+}
+
+// Name returns "github".
+func (gp *GithubProvider) Name() string {
+	return "github"
+}
+
+// AuthCodeURL returns GitHub's authorization URL for the given state.
+func (gp *GithubProvider) AuthCodeURL(state string) string {
+	return gp.OauthConfig.AuthCodeURL(state)
+}
+
+// Authenticate exchanges code for an access token and looks up the
+// GitHub profile of the user it was issued to.
+func (gp *GithubProvider) Authenticate(ctx context.Context, code string, state string) (*Identity, *oauth2.Token, error) {
+	exchangeCtx, span := startProviderSpan(ctx, gp.Name(), "token exchange")
+	token, err := gp.OauthConfig.Exchange(exchangeCtx, code)
+	endSpan(span, err)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting access token: %v", err)
+	}
+
+	profileCtx, span := startProviderSpan(ctx, gp.Name(), "profile fetch")
+	profile, err := gp.fetchProfile(profileCtx, token)
+	endSpan(span, err)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Identity{
+		Provider: gp.Name(),
+		Login:    profile.Login,
+		Name:     profile.Name,
+		ID:       fmt.Sprintf("%d", profile.ID),
+	}, token, nil
+}
+
+// githubProfile is the part of GitHub's current user response we use.
+type githubProfile struct {
+	Login string `json:"login"`
+	Name  string `json:"name"`
+	ID    int64  `json:"id"`
+}
+
+// fetchProfile looks up the GitHub profile of the user token was
+// issued to.
+func (gp *GithubProvider) fetchProfile(ctx context.Context, token *oauth2.Token) (*githubProfile, error) {
+	client := gp.OauthConfig.Client(ctx, token)
+	profileRequest, _ := http.NewRequestWithContext(ctx, http.MethodGet, githubCurrentUserAPI, nil)
+	profileRequest.Header.Add(headerAccept, acceptGitHubV3JSON)
+	profileResponse, err := client.Do(profileRequest)
+	if err != nil {
+		return nil, fmt.Errorf("error getting profile: %v", err)
+	}
+	defer profileResponse.Body.Close()
+	if profileResponse.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error getting profile: github responded %s", profileResponse.Status)
+	}
+
+	profile := &githubProfile{}
+	if err := json.NewDecoder(profileResponse.Body).Decode(profile); err != nil {
+		return nil, fmt.Errorf("error reading github profile response: %v", err)
+	}
+	if len(profile.Login) == 0 {
+		return nil, fmt.Errorf("error github profile has no login")
+	}
+	return profile, nil
+}
+
+// TokenSource returns a source refreshing token with GitHub. Tokens of
+// OAuth apps do not expire, so in practice it only hands token back.
+func (gp *GithubProvider) TokenSource(ctx context.Context, token *oauth2.Token) oauth2.TokenSource {
+	return gp.OauthConfig.TokenSource(ctx, token)
+}
+
+// IsMember reports whether the user holds an active membership of org,
+// or of the team with the given slug within org when team is set.
+func (gp *GithubProvider) IsMember(ctx context.Context, token *oauth2.Token, identity *Identity, org string, team string) (bool, error) {
+	membershipURL := fmt.Sprintf(githubOrgMembershipAPI, url.PathEscape(org))
+	if len(team) > 0 {
+		membershipURL = fmt.Sprintf(githubTeamMembershipAPI,
+			url.PathEscape(org), url.PathEscape(team), url.PathEscape(identity.Login))
+	}
+	client := gp.OauthConfig.Client(ctx, token)
+	membershipRequest, _ := http.NewRequest(http.MethodGet, membershipURL, nil)
+	membershipRequest.Header.Add(headerAccept, acceptGitHubV3JSON)
+	membershipResponse, err := client.Do(membershipRequest)
+	if err != nil {
+		return false, fmt.Errorf("error getting membership: %v", err)
+	}
+	defer membershipResponse.Body.Close()
+	if membershipResponse.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if membershipResponse.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("error getting membership: github responded %s", membershipResponse.Status)
+	}
+	membership := struct {
+		State string `json:"state"`
+	}{}
+	if err := json.NewDecoder(membershipResponse.Body).Decode(&membership); err != nil {
+		return false, fmt.Errorf("error reading github membership response: %v", err)
+	}
+	return membership.State == "active", nil
+}
@@ -1,41 +1,118 @@
 package sessions
 
 import (
+	"context"
 	"fmt"
 	"time"
 
 	cache "github.com/patrickmn/go-cache"
-	"golang.org/x/oauth2"
+	"gopkg.in/mgo.v2/bson"
 )
 
+// MemStore keeps sessions in memory. A session expires once it has not
+// been used for the idle timeout, or once the absolute timeout has
+// passed since the user signed in, whichever comes first.
 type MemStore struct {
-	entries *cache.Cache
+	entries         *cache.Cache
+	idleTimeout     time.Duration
+	absoluteTimeout time.Duration
 }
 
-func NewMemStore(sessionDuration time.Duration, purgeInterval time.Duration) *MemStore {
+func NewMemStore(idleTimeout time.Duration, absoluteTimeout time.Duration, purgeInterval time.Duration) *MemStore {
 	return &MemStore{
-		entries: cache.New(sessionDuration, purgeInterval),
+		entries:         cache.New(idleTimeout, purgeInterval),
+		idleTimeout:     idleTimeout,
+		absoluteTimeout: absoluteTimeout,
 	}
 }
 
-func (ms *MemStore) Save(accessToken string, token *oauth2.Token) error {
-	ms.entries.Set(accessToken, token, cache.DefaultExpiration)
+func (ms *MemStore) Save(sessionID string, state *SessionState) error {
+	ms.entries.Set(sessionID, state, time.Until(ms.Expiry(state)))
 	return nil
 }
 
-func (ms *MemStore) Get(accessToken string) (*oauth2.Token, error) {
-	token, present := ms.entries.Get(accessToken)
+func (ms *MemStore) Get(sessionID string) (*SessionState, error) {
+	state, present := ms.entries.Get(sessionID)
 	if present == false {
 		return nil, fmt.Errorf("token not found in database")
 	}
-	return token.(*oauth2.Token), nil
+	return state.(*SessionState), nil
 }
 
-func (ms *MemStore) Delete(accessToken string) error {
-	_, err := ms.entries.Get(accessToken)
+func (ms *MemStore) Delete(sessionID string) error {
+	_, err := ms.entries.Get(sessionID)
 	if err == false {
 		return fmt.Errorf("error deleting token: %v", err)
 	}
-	ms.entries.Delete(accessToken)
+	ms.entries.Delete(sessionID)
+	return nil
+}
+
+// Touch marks the session as used now, pushing back its idle expiry,
+// and returns when it will now expire. It fails for a session that was
+// deleted or revoked since it was read, rather than bringing it back.
+func (ms *MemStore) Touch(sessionID string, state *SessionState) (time.Time, error) {
+	state.LastSeen = time.Now()
+	expiry := ms.Expiry(state)
+	if !expiry.After(state.LastSeen) {
+		ms.entries.Delete(sessionID)
+		return expiry, errSessionExpired
+	}
+	if err := ms.entries.Replace(sessionID, state, expiry.Sub(state.LastSeen)); err != nil {
+		return expiry, errSessionGone
+	}
+	return expiry, nil
+}
+
+// Expiry returns when state expires if it is not used again.
+func (ms *MemStore) Expiry(state *SessionState) time.Time {
+	idleExpiry := state.LastSeen.Add(ms.idleTimeout)
+	absoluteExpiry := state.Created.Add(ms.absoluteTimeout)
+	if absoluteExpiry.Before(idleExpiry) {
+		return absoluteExpiry
+	}
+	return idleExpiry
+}
+
+// RevokeUser deletes every session belonging to the given user and
+// returns how many were removed.
+func (ms *MemStore) RevokeUser(provider string, login string) int {
+	revoked := 0
+	for sessionID, item := range ms.entries.Items() {
+		state := item.Object.(*SessionState)
+		if state.User.Provider == provider && state.User.Login == login {
+			ms.entries.Delete(sessionID)
+			revoked++
+		}
+	}
+	return revoked
+}
+
+// RevokeRule deletes every session admitted by the access rule with
+// the given ID and returns how many were removed.
+func (ms *MemStore) RevokeRule(ruleID bson.ObjectId) int {
+	revoked := 0
+	for sessionID, item := range ms.entries.Items() {
+		state := item.Object.(*SessionState)
+		if state.AdmittedBy == ruleID {
+			ms.entries.Delete(sessionID)
+			revoked++
+		}
+	}
+	return revoked
+}
+
+// Count returns how many sessions are held, including expired ones
+// not yet purged.
+func (ms *MemStore) Count() int {
+	return ms.entries.ItemCount()
+}
+
+// Ping reports whether the store can hold sessions. They live in our
+// own memory, so this only fails for a store that was never set up.
+func (ms *MemStore) Ping(ctx context.Context) error {
+	if ms == nil || ms.entries == nil {
+		return fmt.Errorf("error session store not initialized")
+	}
 	return nil
 }
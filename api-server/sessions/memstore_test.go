@@ -0,0 +1,55 @@
+package sessions
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestTouchDoesNotRestoreRevokedSessions(t *testing.T) {
+	rule := bson.NewObjectId()
+	revokers := map[string]func(ms *MemStore){
+		"delete":      func(ms *MemStore) { ms.Delete("session") },
+		"revoke user": func(ms *MemStore) { ms.RevokeUser("github", "octocat") },
+		"revoke rule": func(ms *MemStore) { ms.RevokeRule(rule) },
+	}
+	for name, revoke := range revokers {
+		t.Run(name, func(t *testing.T) {
+			ms := NewMemStore(time.Hour, 24*time.Hour, time.Minute)
+			now := time.Now()
+			ms.Save("session", &SessionState{
+				User:       &Identity{Provider: "github", Login: "octocat"},
+				AdmittedBy: rule,
+				Created:    now,
+				LastSeen:   now,
+			})
+			state, err := ms.Get("session")
+			if err != nil {
+				t.Fatal(err)
+			}
+			revoke(ms)
+			if _, err := ms.Touch("session", state); !errors.Is(err, errSessionGone) {
+				t.Errorf("got error %v, want %v", err, errSessionGone)
+			}
+			if _, err := ms.Get("session"); err == nil {
+				t.Error("revoked session is back")
+			}
+		})
+	}
+}
+
+func TestTouchExtendsIdleExpiry(t *testing.T) {
+	ms := NewMemStore(time.Hour, 24*time.Hour, time.Minute)
+	created := time.Now().Add(-30 * time.Minute)
+	state := &SessionState{User: &Identity{Provider: "github", Login: "octocat"}, Created: created, LastSeen: created}
+	ms.Save("session", state)
+	expiry, err := ms.Touch("session", state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if until := time.Until(expiry); until < 59*time.Minute {
+		t.Errorf("session expires in %v, want about an hour", until)
+	}
+}
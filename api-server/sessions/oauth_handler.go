@@ -0,0 +1,269 @@
+package sessions
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/KyleWS/blog-api/api-server/apierrors"
+	"github.com/KyleWS/blog-api/api-server/logging"
+	"github.com/KyleWS/blog-api/api-server/metrics"
+	"github.com/KyleWS/blog-api/api-server/models"
+	cache "github.com/patrickmn/go-cache"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	headerContentType = "Content-Type"
+	headerAccept      = "Accept"
+)
+
+const (
+	contentTypeJSON = "application/json"
+
+	// paramProvider selects which provider to sign in with
+	paramProvider = "provider"
+	// paramReturnTo is the frontend URL to send the user back to
+	// once they have signed in
+	paramReturnTo = "return_to"
+)
+
+// signinState is remembered for each OAuth state value we hand out.
+type signinState struct {
+	// Provider is the name of the provider the user signs in with
+	Provider string
+	// ReturnTo is where the user goes once signed in, when empty the
+	// session is written as JSON instead
+	ReturnTo string
+}
+
+// SignInResponse is the JSON written once a user signs in without
+// asking to be sent back to the frontend.
+type SignInResponse struct {
+	AccessToken string
+	CSRFToken   string
+}
+
+// AuthContext allows us to log in with any of the configured
+// identity providers.
+type AuthContext struct {
+	// Providers maps provider names to the providers users can
+	// sign in with
+	Providers map[string]Provider
+	// DefaultProvider is used when signin does not name a provider
+	DefaultProvider string
+	//stateCache is a cache of previously-generated OAuth state values
+	//mapped to the signinState they were generated for
+	StateCache *cache.Cache
+	// sessionCache lets us save the newly authenticated user's
+	// session and provider token so we can verify them on the fly
+	SessionCache *MemStore
+	// Users decides who is allowed to sign in and with which role
+	Users AccessStore
+	// ReturnOrigins are the frontend origins, such as
+	// "https://blog.example.com", users may ask to be sent back to
+	ReturnOrigins []string
+	// SessionDelivery is how the session reaches the frontend when the
+	// user is sent back to it, DeliverFragment or DeliverCookie. With
+	// cookie delivery JSON signin replies set the cookie as well.
+	SessionDelivery string
+	// CookieSameSite is the SameSite mode of the session cookie
+	CookieSameSite http.SameSite
+	// AllowQueryToken lets clients send their session in the auth
+	// query parameter, where it ends up in logs and browser history
+	AllowQueryToken bool
+	// Audit, when set, records sign ins and refused sign ins
+	Audit models.AuditStore
+}
+
+// NewAuthContext returns an AuthContext for the given providers, the
+// first one is used when signin does not name a provider.
+func NewAuthContext(stateCache *cache.Cache, sessionCache *MemStore, users AccessStore, providers ...Provider) *AuthContext {
+	ctx := &AuthContext{
+		Providers:       make(map[string]Provider),
+		StateCache:      stateCache,
+		SessionCache:    sessionCache,
+		Users:           users,
+		SessionDelivery: DeliverFragment,
+		CookieSameSite:  http.SameSiteLaxMode,
+		AllowQueryToken: true,
+	}
+	for _, provider := range providers {
+		if len(ctx.DefaultProvider) == 0 {
+			ctx.DefaultProvider = provider.Name()
+		}
+		ctx.Providers[provider.Name()] = provider
+	}
+	return ctx
+}
+
+// random value to use as state for oauth
+func newStateValue() string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		panic("error generating random bytes")
+	}
+	return base64.URLEncoding.EncodeToString(buf)
+}
+
+// OAuthSignInHandler handles requests for the oauth sign-on API. The
+// optional provider query parameter picks the identity provider and
+// the optional return_to parameter names the frontend page to send
+// the user back to once they are signed in.
+func (ctx *AuthContext) OAuthSignInHandler(w http.ResponseWriter, r *http.Request) {
+	logging.RequestLogger(w, r).Info("handling signin request")
+	qsParams := r.URL.Query()
+	providerName := qsParams.Get(paramProvider)
+	if len(providerName) == 0 {
+		providerName = ctx.DefaultProvider
+	}
+	provider, found := ctx.Providers[providerName]
+	if !found {
+		apierrors.Write(w, r, http.StatusBadRequest, apierrors.CodeInvalidRequest, fmt.Sprintf("error unknown identity provider: %s", providerName), nil)
+		return
+	}
+	returnTo := qsParams.Get(paramReturnTo)
+	if len(returnTo) > 0 && !ctx.allowedReturnTo(returnTo) {
+		apierrors.Write(w, r, http.StatusBadRequest, apierrors.CodeInvalidRequest, "error return_to is not an allowed frontend origin", nil)
+		return
+	}
+	state := newStateValue()
+	ctx.StateCache.Add(state, &signinState{
+		Provider: providerName,
+		ReturnTo: returnTo,
+	}, cache.DefaultExpiration)
+	redirURL := provider.AuthCodeURL(state)
+	logging.FromContext(r.Context()).WithFields(logrus.Fields{
+		"provider": providerName,
+		"returnTo": returnTo,
+		"state":    state,
+		"redirURL": redirURL,
+	}).Debug("OAuthSignInHandler")
+	http.Redirect(w, r, redirURL, http.StatusSeeOther)
+}
+
+//OAuthReplyHandler handles requests made after authenticating
+//with the OAuth provider, and authorizing our application
+func (ctx *AuthContext) OAuthReplyHandler(w http.ResponseWriter, r *http.Request) {
+	logging.RequestLogger(w, r).Info("handling oauth reply")
+
+	// check the returned state to make sure it matches, consuming it
+	// even when the provider reports an error so it cannot be replayed
+	qsParams := r.URL.Query()
+	stateReturned := qsParams.Get("state")
+	cachedState, found := ctx.StateCache.Get(stateReturned)
+	if found {
+		ctx.StateCache.Delete(stateReturned)
+	}
+
+	// handle OAuth errors if they occurred
+	if providerError := qsParams.Get("error"); len(providerError) > 0 {
+		errorDescription := qsParams.Get("error_description")
+		if len(errorDescription) == 0 {
+			errorDescription = providerError
+		}
+		logging.FromContext(r.Context()).WithFields(logrus.Fields{
+			"err": errorDescription,
+		}).Debug("OAuthReply Error")
+		if found && len(cachedState.(*signinState).ReturnTo) > 0 {
+			redirectWithError(w, r, cachedState.(*signinState).ReturnTo, providerError)
+			return
+		}
+		apierrors.Write(w, r, http.StatusUnauthorized, apierrors.CodeUnauthorized, fmt.Sprintf("error signing in: %s", errorDescription), map[string]string{
+			"error":             providerError,
+			"error_description": qsParams.Get("error_description"),
+		})
+		return
+	}
+
+	if !found {
+		logging.FromContext(r.Context()).WithFields(logrus.Fields{
+			"stateReturned": stateReturned,
+			"cache":         ctx.StateCache,
+		}).Debug("OAuth Reply State Mismatch")
+		apierrors.Write(w, r, http.StatusBadRequest, apierrors.CodeInvalidRequest, "invalid state value returned from oauth provider", nil)
+		return
+	}
+	signin := cachedState.(*signinState)
+	provider, found := ctx.Providers[signin.Provider]
+	if !found {
+		apierrors.Write(w, r, http.StatusBadRequest, apierrors.CodeInvalidRequest, fmt.Sprintf("error unknown identity provider: %v", signin.Provider), nil)
+		return
+	}
+
+	// exchange our code for an access token and find out who it
+	// belongs to
+	identity, token, err := provider.Authenticate(r.Context(), qsParams.Get("code"), stateReturned)
+	if err != nil {
+		logging.FromContext(r.Context()).WithFields(logrus.Fields{
+			"provider": provider.Name(),
+			"err":      err,
+		}).Debug("OAuth Reply Authentication Failed")
+		metrics.Logins.WithLabelValues(provider.Name(), metrics.LoginFailure).Inc()
+		if len(signin.ReturnTo) > 0 {
+			redirectWithError(w, r, signin.ReturnTo, "server_error")
+			return
+		}
+		apierrors.Write(w, r, http.StatusBadGateway, apierrors.CodeBadGateway, fmt.Sprintf("error authenticating with %s: %v", provider.Name(), err), nil)
+		return
+	}
+
+	// If we have gotten this far, time to save that access token
+	if role, admittedBy := ctx.roleFor(r.Context(), provider, identity, token); len(role) > 0 {
+		sessionID := newSessionID()
+		state := NewSessionState(provider, token, identity, role)
+		state.AdmittedBy = admittedBy
+		ctx.SessionCache.Save(sessionID, state)
+		metrics.Logins.WithLabelValues(provider.Name(), metrics.LoginSuccess).Inc()
+		ctx.auditLogin(r, models.AuditLogin, identity, map[string]interface{}{"role": role})
+		if len(signin.ReturnTo) > 0 {
+			ctx.redirectWithSession(w, r, signin.ReturnTo, sessionID, state)
+			return
+		}
+		if ctx.SessionDelivery == DeliverCookie {
+			ctx.setSessionCookie(w, sessionID)
+		}
+		w.Header().Add(headerContentType, contentTypeJSON)
+		w.Header().Add(headerAuthorization, sessionID)
+		w.Header().Add(headerCSRFToken, state.CSRFToken)
+		tokenAccept := &SignInResponse{
+			AccessToken: sessionID,
+			CSRFToken:   state.CSRFToken,
+		}
+		json.NewEncoder(w).Encode(tokenAccept)
+		return
+	}
+	logging.FromContext(r.Context()).WithFields(logrus.Fields{
+		"provider": identity.Provider,
+		"name":     identity.Name,
+		"login":    identity.Login,
+		"id":       identity.ID,
+	}).Warn("error non-whitelisted user tried to authenticate")
+	metrics.Logins.WithLabelValues(provider.Name(), metrics.LoginDenied).Inc()
+	ctx.auditLogin(r, models.AuditLoginDenied, identity, map[string]interface{}{"name": identity.Name, "id": identity.ID})
+	if len(signin.ReturnTo) > 0 {
+		redirectWithError(w, r, signin.ReturnTo, "access_denied")
+		return
+	}
+	apierrors.Write(w, r, http.StatusForbidden, apierrors.CodeForbidden,
+		fmt.Sprintf("error user %s, %s, %v is not allowed to authenticate. Administrators have been notified.",
+			identity.Name, identity.Login, identity.ID), nil)
+}
+
+// auditLogin records identity signing in, or being refused, in the
+// audit log if there is one.
+func (ctx *AuthContext) auditLogin(r *http.Request, action string, identity *Identity, details map[string]interface{}) {
+	if ctx.Audit == nil {
+		return
+	}
+	event := models.NewAuditEvent(r.Context(), identity.Provider+":"+identity.Login, action)
+	event.Details = details
+	if err := ctx.Audit.AppendAudit(r.Context(), event); err != nil {
+		logging.FromContext(r.Context()).WithFields(logrus.Fields{
+			"event": event,
+			"err":   err,
+		}).Error("error recording audit event")
+	}
+}
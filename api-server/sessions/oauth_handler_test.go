@@ -0,0 +1,61 @@
+package sessions
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/KyleWS/blog-api/api-server/apierrors"
+	"github.com/patrickmn/go-cache"
+)
+
+func TestOAuthReplyProviderError(t *testing.T) {
+	cases := []struct {
+		name     string
+		returnTo string
+		status   int
+		location string
+		message  string
+	}{
+		{
+			name:     "sent back to the frontend",
+			returnTo: "https://blog.example/signin#stale",
+			status:   http.StatusSeeOther,
+			location: "https://blog.example/signin#error=access_denied",
+		},
+		{
+			name:    "no frontend to send back to",
+			status:  http.StatusUnauthorized,
+			message: "error signing in: access_denied",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := &AuthContext{StateCache: cache.New(time.Minute, time.Minute)}
+			ctx.StateCache.Add("state", &signinState{Provider: "github", ReturnTo: c.returnTo}, cache.DefaultExpiration)
+			r := httptest.NewRequest(http.MethodGet, "/v1/oauth/reply?error=access_denied&state=state", nil)
+			w := httptest.NewRecorder()
+			ctx.OAuthReplyHandler(w, r)
+			if w.Code != c.status {
+				t.Fatalf("got status %d, want %d: %s", w.Code, c.status, w.Body)
+			}
+			if _, found := ctx.StateCache.Get("state"); found {
+				t.Error("state was not consumed")
+			}
+			if len(c.location) > 0 && w.Header().Get("Location") != c.location {
+				t.Errorf("got location %q, want %q", w.Header().Get("Location"), c.location)
+			}
+			if len(c.message) > 0 {
+				envelope := &apierrors.Envelope{}
+				if err := json.NewDecoder(w.Body).Decode(envelope); err != nil {
+					t.Fatal(err)
+				}
+				if envelope.Message != c.message {
+					t.Errorf("got message %q, want %q", envelope.Message, c.message)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,495 @@
+package sessions
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	// oidcDiscoveryPath is appended to the issuer to find the
+	// provider's discovery document
+	oidcDiscoveryPath = "/.well-known/openid-configuration"
+
+	// defaultUsernameClaim is the ID token claim used as the login
+	// when none is configured
+	defaultUsernameClaim = "preferred_username"
+
+	// oidcClockSkew is how far our clock may drift from the
+	// provider's when checking token lifetimes
+	oidcClockSkew = time.Minute
+
+	// oidcKeysMaxAge is how long fetched signing keys are trusted
+	// before the key set is downloaded again
+	oidcKeysMaxAge = time.Hour
+
+	// oidcKeysMinRefresh stops unknown key IDs from making us hammer
+	// the provider's key endpoint
+	oidcKeysMinRefresh = time.Minute
+
+	// oidcHTTPTimeout bounds every request to the provider when no
+	// client is configured, so a hung provider cannot hold up startup
+	// or sign in
+	oidcHTTPTimeout = 10 * time.Second
+)
+
+// oidcCurves are the curves the ECDSA algorithms must be used with
+var oidcCurves = map[string]string{
+	"ES256": "P-256",
+	"ES384": "P-384",
+	"ES512": "P-521",
+}
+
+// OIDCConfig holds the settings for signing in with a generic OpenID
+// Connect identity provider.
+type OIDCConfig struct {
+	// Name identifies the provider at signin, defaults to "oidc"
+	Name string
+	// Issuer is the provider's issuer URL, the discovery document is
+	// fetched from below it
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes are requested in addition to "openid"
+	Scopes []string
+	// UsernameClaim is the ID token claim used as the login,
+	// defaults to "preferred_username"
+	UsernameClaim string
+	// HTTPClient is used to talk to the provider, defaults to a client
+	// giving up after oidcHTTPTimeout
+	HTTPClient *http.Client
+}
+
+// OIDCProvider signs users in with an OpenID Connect identity
+// provider, verifying the ID token it returns.
+type OIDCProvider struct {
+	name          string
+	issuer        string
+	usernameClaim string
+	oauthConfig   *oauth2.Config
+	jwksURI       string
+	client        *http.Client
+
+	keysLock    sync.Mutex
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+// discoveryDocument is the subset of the provider metadata we use.
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// jsonWebKey is a single key of a JWK set.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// NewOIDCProvider fetches the issuer's discovery document and returns
+// a provider configured from it, giving up once ctx is done.
+func NewOIDCProvider(ctx context.Context, config *OIDCConfig) (*OIDCProvider, error) {
+	if len(config.Issuer) == 0 || len(config.ClientID) == 0 {
+		return nil, fmt.Errorf("error oidc provider requires an issuer and client id")
+	}
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: oidcHTTPTimeout}
+	}
+	issuer := strings.TrimSuffix(config.Issuer, "/")
+
+	doc := &discoveryDocument{}
+	if err := getJSON(ctx, client, issuer+oidcDiscoveryPath, doc); err != nil {
+		return nil, fmt.Errorf("error fetching oidc discovery document: %v", err)
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("error oidc discovery document issuer %q does not match %q", doc.Issuer, issuer)
+	}
+	if len(doc.AuthorizationEndpoint) == 0 || len(doc.TokenEndpoint) == 0 || len(doc.JWKSURI) == 0 {
+		return nil, fmt.Errorf("error oidc discovery document is missing endpoints")
+	}
+
+	name := config.Name
+	if len(name) == 0 {
+		name = "oidc"
+	}
+	usernameClaim := config.UsernameClaim
+	if len(usernameClaim) == 0 {
+		usernameClaim = defaultUsernameClaim
+	}
+	scopes := []string{"openid"}
+	for _, scope := range config.Scopes {
+		if scope != "openid" && len(scope) > 0 {
+			scopes = append(scopes, scope)
+		}
+	}
+	return &OIDCProvider{
+		name:          name,
+		issuer:        doc.Issuer,
+		usernameClaim: usernameClaim,
+		oauthConfig: &oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectURL,
+			Scopes:       scopes,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  doc.AuthorizationEndpoint,
+				TokenURL: doc.TokenEndpoint,
+			},
+		},
+		jwksURI: doc.JWKSURI,
+		client:  client,
+	}, nil
+}
+
+// Name returns the configured provider name.
+func (op *OIDCProvider) Name() string {
+	return op.name
+}
+
+// AuthCodeURL returns the provider's authorization URL for the given
+// state, with a nonce derived from the state.
+func (op *OIDCProvider) AuthCodeURL(state string) string {
+	return op.oauthConfig.AuthCodeURL(state, oauth2.SetAuthURLParam("nonce", nonceForState(state)))
+}
+
+// Authenticate exchanges code for tokens, verifies the returned ID
+// token and reads the user's identity from its claims.
+func (op *OIDCProvider) Authenticate(ctx context.Context, code string, state string) (*Identity, *oauth2.Token, error) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, op.client)
+	exchangeCtx, span := startProviderSpan(ctx, op.name, "token exchange")
+	token, err := op.oauthConfig.Exchange(exchangeCtx, code)
+	endSpan(span, err)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting access token: %v", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || len(rawIDToken) == 0 {
+		return nil, nil, fmt.Errorf("error token response has no id_token")
+	}
+	verifyCtx, span := startProviderSpan(ctx, op.name, "id token verification")
+	claims, err := op.verifyIDToken(verifyCtx, rawIDToken, nonceForState(state))
+	endSpan(span, err)
+	if err != nil {
+		return nil, nil, err
+	}
+	login, _ := claims[op.usernameClaim].(string)
+	if len(login) == 0 {
+		return nil, nil, fmt.Errorf("error id token has no %q claim", op.usernameClaim)
+	}
+	name, _ := claims["name"].(string)
+	subject, _ := claims["sub"].(string)
+	return &Identity{
+		Provider: op.name,
+		Login:    login,
+		Name:     name,
+		ID:       subject,
+	}, token, nil
+}
+
+// TokenSource returns a source refreshing token at the provider's
+// token endpoint once it expires.
+func (op *OIDCProvider) TokenSource(ctx context.Context, token *oauth2.Token) oauth2.TokenSource {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, op.client)
+	return op.oauthConfig.TokenSource(ctx, token)
+}
+
+// verifyIDToken checks the signature and standard claims of a
+// compact serialized ID token and returns its claims.
+func (op *OIDCProvider) verifyIDToken(ctx context.Context, rawIDToken string, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("error id token is malformed")
+	}
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("error decoding id token header: %v", err)
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return nil, fmt.Errorf("error decoding id token header: %v", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("error decoding id token signature: %v", err)
+	}
+	key, err := op.signingKey(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("error decoding id token claims: %v", err)
+	}
+	claims := map[string]interface{}{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("error decoding id token claims: %v", err)
+	}
+
+	if iss, _ := claims["iss"].(string); iss != op.issuer {
+		return nil, fmt.Errorf("error id token issued by %q, expected %q", iss, op.issuer)
+	}
+	if !audienceContains(claims["aud"], op.oauthConfig.ClientID) {
+		return nil, fmt.Errorf("error id token was not issued for this client")
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != op.oauthConfig.ClientID {
+		return nil, fmt.Errorf("error id token authorized party %q is not this client", azp)
+	}
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("error id token has no expiry")
+	}
+	if now.Add(-oidcClockSkew).After(time.Unix(int64(exp), 0)) {
+		return nil, fmt.Errorf("error id token has expired")
+	}
+	if iat, ok := claims["iat"].(float64); ok && time.Unix(int64(iat), 0).After(now.Add(oidcClockSkew)) {
+		return nil, fmt.Errorf("error id token was issued in the future")
+	}
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		return nil, fmt.Errorf("error id token nonce does not match")
+	}
+	return claims, nil
+}
+
+// signingKey returns the provider key with the given ID, downloading
+// the key set again when the key is unknown or the cache is stale.
+func (op *OIDCProvider) signingKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	op.keysLock.Lock()
+	defer op.keysLock.Unlock()
+
+	age := time.Since(op.keysFetched)
+	key, found := lookupKey(op.keys, kid)
+	if found && age < oidcKeysMaxAge {
+		return key, nil
+	}
+	if op.keys == nil || age > oidcKeysMinRefresh {
+		keys, err := op.fetchKeys(ctx)
+		if err != nil {
+			return nil, err
+		}
+		op.keys = keys
+		op.keysFetched = time.Now()
+		key, found = lookupKey(op.keys, kid)
+	}
+	if !found {
+		return nil, fmt.Errorf("error no signing key %q published by provider", kid)
+	}
+	return key, nil
+}
+
+// lookupKey finds a key by ID, an empty ID matches only when the set
+// holds a single key.
+func lookupKey(keys map[string]crypto.PublicKey, kid string) (crypto.PublicKey, bool) {
+	if len(kid) == 0 && len(keys) == 1 {
+		for _, key := range keys {
+			return key, true
+		}
+	}
+	key, found := keys[kid]
+	return key, found
+}
+
+// fetchKeys downloads and parses the provider's signing keys.
+func (op *OIDCProvider) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	set := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := getJSON(ctx, op.client, op.jwksURI, &set); err != nil {
+		return nil, fmt.Errorf("error fetching oidc signing keys: %v", err)
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if len(jwk.Use) > 0 && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			// skip key types we do not understand rather than
+			// failing every login
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("error oidc provider published no usable signing keys")
+	}
+	return keys, nil
+}
+
+// publicKey converts the JWK into an RSA or ECDSA public key.
+func (jwk *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("error rsa exponent out of range")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("error unsupported curve %q", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("error ec key is not on curve %s", jwk.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("error unsupported key type %q", jwk.Kty)
+}
+
+// verifyJWTSignature checks signature over signed using the algorithm
+// named in the token header. Only asymmetric algorithms are accepted.
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed []byte, signature []byte) error {
+	var hash crypto.Hash
+	switch {
+	case strings.HasSuffix(alg, "256"):
+		hash = crypto.SHA256
+	case strings.HasSuffix(alg, "384"):
+		hash = crypto.SHA384
+	case strings.HasSuffix(alg, "512"):
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("error unsupported id token algorithm %q", alg)
+	}
+	hasher := hash.New()
+	hasher.Write(signed)
+	digest := hasher.Sum(nil)
+
+	switch {
+	case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"):
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("error id token algorithm %q does not match key", alg)
+		}
+		var err error
+		if alg[0] == 'R' {
+			err = rsa.VerifyPKCS1v15(rsaKey, hash, digest, signature)
+		} else {
+			err = rsa.VerifyPSS(rsaKey, hash, digest, signature, nil)
+		}
+		if err != nil {
+			return fmt.Errorf("error invalid id token signature")
+		}
+		return nil
+	case strings.HasPrefix(alg, "ES"):
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || ecKey.Curve.Params().Name != oidcCurves[alg] {
+			return fmt.Errorf("error id token algorithm %q does not match key", alg)
+		}
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("error invalid id token signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return fmt.Errorf("error invalid id token signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("error unsupported id token algorithm %q", alg)
+}
+
+// audienceContains reports whether the aud claim, a string or list of
+// strings, names clientID.
+func audienceContains(aud interface{}, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, entry := range aud {
+			if entry == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nonceForState derives the OIDC nonce sent along with state so the
+// ID token can be tied to the signin that requested it.
+func nonceForState(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding key parameter: %v", err)
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
+
+// getJSON fetches url and decodes the JSON response into target.
+func getJSON(ctx context.Context, client *http.Client, url string, target interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add(headerAccept, "application/json")
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
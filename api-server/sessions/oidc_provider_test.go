@@ -0,0 +1,215 @@
+package sessions
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testIdP is an OIDC provider serving discovery, a key set and a token
+// endpoint that answers every code with idToken.
+type testIdP struct {
+	server  *httptest.Server
+	rsaKey  *rsa.PrivateKey
+	ecKey   *ecdsa.PrivateKey
+	idToken string
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &testIdP{rsaKey: rsaKey, ecKey: ecKey}
+	encode := func(n *big.Int) string { return base64.RawURLEncoding.EncodeToString(n.Bytes()) }
+	mux := http.NewServeMux()
+	mux.HandleFunc(oidcDiscoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []jsonWebKey{
+			{Kty: "RSA", Kid: "rsa", Use: "sig", N: encode(rsaKey.N), E: encode(big.NewInt(int64(rsaKey.E)))},
+			{Kty: "EC", Kid: "ec", Use: "sig", Crv: "P-384", X: encode(ecKey.X), Y: encode(ecKey.Y)},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idp.idToken,
+		})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// sign returns a compact serialized token over header and claims. RS
+// algorithms sign with the RSA key and ES algorithms with the EC key.
+func (idp *testIdP) sign(t *testing.T, header map[string]string, claims map[string]interface{}) string {
+	t.Helper()
+	encode := func(v interface{}) string {
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(encoded)
+	}
+	signed := encode(header) + "." + encode(claims)
+	digest := crypto.SHA256.New()
+	digest.Write([]byte(signed))
+	var signature []byte
+	if strings.HasPrefix(header["alg"], "ES") {
+		r, s, err := ecdsa.Sign(rand.Reader, idp.ecKey, digest.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := (idp.ecKey.Curve.Params().BitSize + 7) / 8
+		signature = make([]byte, 2*size)
+		r.FillBytes(signature[:size])
+		s.FillBytes(signature[size:])
+	} else {
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, idp.rsaKey, crypto.SHA256, digest.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOIDCAuthenticate(t *testing.T) {
+	idp := newTestIdP(t)
+	provider, err := NewOIDCProvider(context.Background(), &OIDCConfig{
+		Issuer:      idp.server.URL,
+		ClientID:    "blog",
+		RedirectURL: "https://blog.example/v1/oauth/reply",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const state = "state"
+	now := time.Now()
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		claims := map[string]interface{}{
+			"iss":                idp.server.URL,
+			"aud":                "blog",
+			"sub":                "1234",
+			"exp":                now.Add(time.Hour).Unix(),
+			"iat":                now.Unix(),
+			"nonce":              nonceForState(state),
+			"preferred_username": "octocat",
+		}
+		for name, value := range changes {
+			claims[name] = value
+		}
+		return claims
+	}
+	rs256 := map[string]string{"alg": "RS256", "kid": "rsa"}
+	cases := []struct {
+		name  string
+		token func() string
+		// err is part of the error wanted, empty when the token is good
+		err string
+	}{
+		{
+			name:  "good token",
+			token: func() string { return idp.sign(t, rs256, claims(nil)) },
+		},
+		{
+			name: "bad signature",
+			err:  "invalid id token signature",
+			token: func() string {
+				token := idp.sign(t, rs256, claims(nil))
+				return token[:strings.LastIndex(token, ".")] + "." + strings.Split(idp.sign(t, rs256, claims(map[string]interface{}{"sub": "5678"})), ".")[2]
+			},
+		},
+		{
+			name:  "wrong audience",
+			err:   "not issued for this client",
+			token: func() string { return idp.sign(t, rs256, claims(map[string]interface{}{"aud": []string{"other"}})) },
+		},
+		{
+			name:  "wrong issuer",
+			err:   "issued by",
+			token: func() string { return idp.sign(t, rs256, claims(map[string]interface{}{"iss": "https://idp.example"})) },
+		},
+		{
+			name: "expired",
+			err:  "has expired",
+			token: func() string {
+				return idp.sign(t, rs256, claims(map[string]interface{}{"exp": now.Add(-time.Hour).Unix()}))
+			},
+		},
+		{
+			name: "nonce mismatch",
+			err:  "nonce does not match",
+			token: func() string {
+				return idp.sign(t, rs256, claims(map[string]interface{}{"nonce": nonceForState("other")}))
+			},
+		},
+		{
+			name:  "unknown key",
+			err:   "no signing key",
+			token: func() string { return idp.sign(t, map[string]string{"alg": "RS256", "kid": "gone"}, claims(nil)) },
+		},
+		{
+			name:  "curve does not match the algorithm",
+			err:   "does not match key",
+			token: func() string { return idp.sign(t, map[string]string{"alg": "ES256", "kid": "ec"}, claims(nil)) },
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			idp.idToken = c.token()
+			identity, _, err := provider.Authenticate(context.Background(), "code", state)
+			if len(c.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("got identity %+v and error %v, want %q", identity, err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if identity.Login != "octocat" || identity.ID != "1234" {
+				t.Errorf("got identity %+v", identity)
+			}
+		})
+	}
+}
+
+func TestOIDCDiscoveryGivesUpAtDeadline(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := NewOIDCProvider(ctx, &OIDCConfig{Issuer: server.URL, ClientID: "blog"}); err == nil {
+		t.Error("got no error")
+	}
+}
@@ -0,0 +1,37 @@
+package sessions
+
+import (
+	"context"
+
+	"golang.org/x/oauth2"
+)
+
+// Identity describes the user an identity provider vouched for.
+type Identity struct {
+	// Provider is the name of the provider that authenticated the user
+	Provider string
+	// Login is the username checked against the whitelist
+	Login string
+	// Name is the display name of the user, if the provider shares it
+	Name string
+	// ID is the provider's stable identifier for the user
+	ID string
+}
+
+// Provider is an OAuth based identity provider users can sign in
+// with.
+type Provider interface {
+	// Name returns the identifier used to select the provider when
+	// signing in.
+	Name() string
+	// AuthCodeURL returns the URL users are sent to in order to
+	// authorize our application. state is the one-time value
+	// that will come back with the reply.
+	AuthCodeURL(state string) string
+	// Authenticate exchanges the code from the provider's reply for a
+	// token and returns the identity of the user it belongs to.
+	Authenticate(ctx context.Context, code string, state string) (*Identity, *oauth2.Token, error)
+	// TokenSource returns a source that hands out token until it
+	// expires and then refreshes it with the provider.
+	TokenSource(ctx context.Context, token *oauth2.Token) oauth2.TokenSource
+}
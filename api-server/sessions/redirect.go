@@ -0,0 +1,74 @@
+package sessions
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DeliverFragment sends the session back to the frontend in the
+	// URL fragment, which browsers never send to servers
+	DeliverFragment = "fragment"
+	// DeliverCookie sends the session back to the frontend in an
+	// HttpOnly cookie on the API's origin, along with the CSRF token
+	// in the URL fragment
+	DeliverCookie = "cookie"
+)
+
+// allowedReturnTo reports whether returnTo is an absolute URL on one
+// of the configured frontend origins.
+func (ctx *AuthContext) allowedReturnTo(returnTo string) bool {
+	parsed, err := url.Parse(returnTo)
+	if err != nil || len(parsed.Host) == 0 || len(parsed.User.String()) > 0 {
+		return false
+	}
+	if parsed.Scheme != "https" && parsed.Scheme != "http" {
+		return false
+	}
+	origin := parsed.Scheme + "://" + strings.ToLower(parsed.Host)
+	for _, allowed := range ctx.ReturnOrigins {
+		if origin == strings.ToLower(strings.TrimSuffix(strings.TrimSpace(allowed), "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// redirectWithSession sends the newly signed in user back to the
+// frontend, handing over the session the configured way.
+func (ctx *AuthContext) redirectWithSession(w http.ResponseWriter, r *http.Request, returnTo string, sessionID string, state *SessionState) {
+	expiresIn := strconv.Itoa(int(time.Until(ctx.SessionCache.Expiry(state)).Seconds()))
+	fragment := url.Values{}
+	if ctx.SessionDelivery == DeliverCookie {
+		// the frontend cannot read the cookie, but needs the CSRF
+		// token to make changes with it
+		ctx.setSessionCookie(w, sessionID)
+		fragment.Set("csrf_token", state.CSRFToken)
+		fragment.Set("expires_in", expiresIn)
+		http.Redirect(w, r, withFragment(returnTo, fragment), http.StatusSeeOther)
+		return
+	}
+	fragment.Set("access_token", sessionID)
+	fragment.Set("token_type", "Bearer")
+	fragment.Set("expires_in", expiresIn)
+	http.Redirect(w, r, withFragment(returnTo, fragment), http.StatusSeeOther)
+}
+
+// redirectWithError sends the user back to the frontend with an OAuth
+// style error code in the fragment.
+func redirectWithError(w http.ResponseWriter, r *http.Request, returnTo string, code string) {
+	fragment := url.Values{}
+	fragment.Set("error", code)
+	http.Redirect(w, r, withFragment(returnTo, fragment), http.StatusSeeOther)
+}
+
+// withFragment replaces any fragment of target with the encoded values.
+func withFragment(target string, fragment url.Values) string {
+	if i := strings.Index(target, "#"); i >= 0 {
+		target = target[:i]
+	}
+	return target + "#" + fragment.Encode()
+}
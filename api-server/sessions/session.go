@@ -1,9 +1,16 @@
 package sessions
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/KyleWS/blog-api/api-server/logging"
 	"github.com/sirupsen/logrus"
 )
 
@@ -11,24 +18,149 @@ const headerAuthorization = "Authorization"
 const paramAuthorization = "auth"
 const schemeBearer = "Bearer "
 
-func CheckAuthToken(r *http.Request, ms *MemStore) error {
-	authHeader := r.Header.Get(headerAuthorization)
-	if len(authHeader) == 0 {
-		authHeader = r.URL.Query().Get("auth")
+// cookieSession holds the session ID for browser clients that
+// signed in with cookie delivery
+const cookieSession = "blogapi_session"
+
+// headerCSRFToken carries the session's CSRF token. We send it on
+// every authenticated response and browser clients using the session
+// cookie must send it back on requests that change anything.
+const headerCSRFToken = "X-CSRF-Token"
+
+// headerSessionExpiresIn tells clients how many seconds their session
+// has left before it expires unless it is used again
+const headerSessionExpiresIn = "Session-Expires-In"
+
+var (
+	errTokenExpired   = errors.New("provider token expired and cannot be refreshed")
+	errSessionExpired = errors.New("session expired")
+	errSessionGone    = errors.New("session ended or revoked")
+)
+
+// newSessionID returns a random value to identify a session by. It is
+// what clients send back to us, provider tokens never leave the
+// server.
+func newSessionID() string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		panic("error generating random bytes")
 	}
+	return base64.URLEncoding.EncodeToString(buf)
+}
+
+// CheckAuthToken returns the session for the access token sent with
+// the request in the Authorization header, the session cookie or,
+// unless disabled, the auth query parameter.
+//
+// Using a session extends its idle timeout, the time it has left is
+// reported in the Session-Expires-In header. Expired provider tokens
+// are refreshed when the provider gave us a refresh token. Requests
+// authenticated by cookie that are not GET, HEAD or OPTIONS must carry
+// the session's CSRF token in the X-CSRF-Token header.
+func (ctx *AuthContext) CheckAuthToken(w http.ResponseWriter, r *http.Request) (*SessionState, error) {
+	authHeader, fromCookie := ctx.sessionID(r)
 	if len(authHeader) == 0 {
-		return fmt.Errorf("error access token header missing")
+		return nil, fmt.Errorf("error access token header missing")
 	}
-	token, err := ms.Get(authHeader)
+	state, err := ctx.SessionCache.Get(authHeader)
 	if err != nil {
-		return fmt.Errorf("error checking authorization in store: %v", err)
+		return nil, fmt.Errorf("error checking authorization in store: %v", err)
 	}
-	if !token.Valid() {
-		logrus.WithFields(logrus.Fields{
-			"access_token": token.AccessToken,
-			"token_expire": token.Expiry,
+	if fromCookie && !safeMethod(r.Method) {
+		sent := r.Header.Get(headerCSRFToken)
+		if subtle.ConstantTimeCompare([]byte(sent), []byte(state.CSRFToken)) != 1 {
+			logging.FromContext(r.Context()).WithFields(logrus.Fields{
+				"user":   state.Principal(),
+				"method": r.Method,
+				"uri":    r.URL.RequestURI(),
+			}).Warn("missing or wrong csrf token on cookie authenticated request")
+			return nil, fmt.Errorf("error csrf token missing or invalid")
+		}
+	}
+
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if err := state.refreshToken(); err != nil {
+		logging.FromContext(r.Context()).WithFields(logrus.Fields{
+			"user":         state.Principal(),
+			"token_expire": state.Token.Expiry,
+			"err":          err,
 		}).Warn("invalid access token used in request")
-		return fmt.Errorf("error validating access token")
+		ctx.SessionCache.Delete(authHeader)
+		return nil, fmt.Errorf("error validating access token")
+	}
+	expiry, err := ctx.SessionCache.Touch(authHeader, state)
+	if err != nil {
+		return nil, fmt.Errorf("error validating access token: %v", err)
+	}
+	w.Header().Set(headerSessionExpiresIn, strconv.Itoa(int(time.Until(expiry).Seconds())))
+	w.Header().Set(headerCSRFToken, state.CSRFToken)
+	return state, nil
+}
+
+// SessionPrincipal returns the principal of the session r carries
+// without using it, so its idle timeout is left alone. ok is false
+// when r carries no session we know about.
+func (ctx *AuthContext) SessionPrincipal(r *http.Request) (principal string, ok bool) {
+	sessionID, _ := ctx.sessionID(r)
+	if len(sessionID) == 0 {
+		return "", false
+	}
+	state, err := ctx.SessionCache.Get(sessionID)
+	if err != nil {
+		return "", false
 	}
-	return nil
+	return state.Principal(), true
+}
+
+// SessionRole returns the role of the session r carries without using
+// it, like SessionPrincipal.
+func (ctx *AuthContext) SessionRole(r *http.Request) (role string, ok bool) {
+	sessionID, _ := ctx.sessionID(r)
+	if len(sessionID) == 0 {
+		return "", false
+	}
+	state, err := ctx.SessionCache.Get(sessionID)
+	if err != nil {
+		return "", false
+	}
+	return state.Role, true
+}
+
+// sessionID returns the session ID sent with r and whether it came
+// from the session cookie.
+func (ctx *AuthContext) sessionID(r *http.Request) (string, bool) {
+	fromCookie := false
+	authHeader := r.Header.Get(headerAuthorization)
+	if len(authHeader) == 0 {
+		if cookie, err := r.Cookie(cookieSession); err == nil {
+			authHeader = cookie.Value
+			fromCookie = true
+		}
+	}
+	if len(authHeader) == 0 && ctx.AllowQueryToken {
+		authHeader = r.URL.Query().Get(paramAuthorization)
+	}
+	if len(authHeader) > len(schemeBearer) && authHeader[:len(schemeBearer)] == schemeBearer {
+		authHeader = authHeader[len(schemeBearer):]
+	}
+	return authHeader, fromCookie
+}
+
+// safeMethod reports whether requests with method only read data.
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// setSessionCookie hands the session ID to a browser in a cookie
+// scripts cannot read.
+func (ctx *AuthContext) setSessionCookie(w http.ResponseWriter, sessionID string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieSession,
+		Value:    sessionID,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: ctx.CookieSameSite,
+	})
 }
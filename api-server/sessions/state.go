@@ -0,0 +1,76 @@
+package sessions
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// SessionState is what we remember about a signed in user.
+type SessionState struct {
+	// Token is the token the identity provider issued
+	Token *oauth2.Token
+	// User is who the provider says signed in
+	User *Identity
+	// Role is the role the user was granted when signing in
+	Role string
+	// AdmittedBy is the access rule that granted Role, empty for users
+	// in the access store
+	AdmittedBy bson.ObjectId
+	// Created is when the user signed in, no session outlives the
+	// store's absolute timeout counted from here
+	Created time.Time
+	// LastSeen is when the session was last used, the store's idle
+	// timeout is counted from here
+	LastSeen time.Time
+	// CSRFToken must accompany requests authenticated by the session
+	// cookie that change anything
+	CSRFToken string
+
+	// tokenSource refreshes Token through the provider when it expires
+	tokenSource oauth2.TokenSource
+	// lock guards Token and LastSeen, which concurrent requests on the
+	// same session update
+	lock sync.Mutex
+}
+
+// NewSessionState returns the state for a user who just signed in with
+// provider.
+func NewSessionState(provider Provider, token *oauth2.Token, user *Identity, role string) *SessionState {
+	now := time.Now()
+	return &SessionState{
+		Token:       token,
+		User:        user,
+		Role:        role,
+		Created:     now,
+		LastSeen:    now,
+		CSRFToken:   newSessionID(),
+		tokenSource: provider.TokenSource(context.Background(), token),
+	}
+}
+
+// Principal returns "provider:login" for the signed in user, used to
+// record who made a change.
+func (state *SessionState) Principal() string {
+	return state.User.Provider + ":" + state.User.Login
+}
+
+// refreshToken makes sure Token is valid, using the refresh token to
+// get a new one from the provider if it has expired.
+func (state *SessionState) refreshToken() error {
+	if state.Token.Valid() {
+		return nil
+	}
+	if state.tokenSource == nil || len(state.Token.RefreshToken) == 0 {
+		return errTokenExpired
+	}
+	token, err := state.tokenSource.Token()
+	if err != nil {
+		return err
+	}
+	state.Token = token
+	return nil
+}
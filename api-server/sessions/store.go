@@ -1,11 +1,13 @@
 package sessions
 
-import "golang.org/x/oauth2"
+import "time"
 
 type Store interface {
-	Save(accessToken string, token *oauth2.Token) error
+	Save(sessionID string, state *SessionState) error
 
-	Get(name string) (*oauth2.Token, error)
+	Get(sessionID string) (*SessionState, error)
 
-	Delete(name string) error
+	Delete(sessionID string) error
+
+	Touch(sessionID string, state *SessionState) (time.Time, error)
 }
@@ -0,0 +1,30 @@
+package sessions
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/KyleWS/blog-api/api-server/sessions")
+
+// startProviderSpan starts a span for a call we make to the identity
+// provider named provider.
+func startProviderSpan(ctx context.Context, provider string, name string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, provider+" "+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("blogapi.identity_provider", provider)),
+	)
+}
+
+// endSpan ends span, marking it failed when err is not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,119 @@
+// Package tracing sets up OpenTelemetry tracing and starts a server
+// span for every request.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/KyleWS/blog-api/api-server/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// ExporterNone keeps tracing off, trace context is still passed on
+	ExporterNone = ""
+	// ExporterStdout writes finished spans to stdout as JSON
+	ExporterStdout = "stdout"
+	// ExporterOTLP sends spans to an OpenTelemetry collector over
+	// HTTP, configured by the standard OTEL_EXPORTER_OTLP_* variables
+	ExporterOTLP = "otlp"
+)
+
+const serviceName = "blog-api"
+
+// requestIDKey is the span attribute holding our RequestID
+const requestIDKey = attribute.Key("blogapi.request_id")
+
+// Setup installs the W3C trace context propagator and a tracer
+// provider sending spans to exporter. The returned function flushes
+// and stops the exporter.
+func Setup(ctx context.Context, exporter string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterOTLP:
+		spanExporter, err = otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("error unknown trace exporter %q, expected stdout or otlp", exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s trace exporter: %v", exporter, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, fmt.Errorf("error describing trace resource: %v", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Traced starts a server span for every request handled by handler,
+// continuing the trace the caller sent in the traceparent header.
+type Traced struct {
+	Handler http.Handler
+	// Routes is consulted to name spans after the route they match
+	Routes *http.ServeMux
+	tracer trace.Tracer
+}
+
+// NewTraced returns handler traced, routes is the mux behind handler.
+func NewTraced(handler http.Handler, routes *http.ServeMux) *Traced {
+	return &Traced{
+		Handler: handler,
+		Routes:  routes,
+		tracer:  otel.Tracer("github.com/KyleWS/blog-api/api-server/tracing"),
+	}
+}
+
+func (t *Traced) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	name := r.Method
+	attrs := []attribute.KeyValue{
+		semconv.HTTPRequestMethodKey.String(r.Method),
+		semconv.URLPath(r.URL.Path),
+		semconv.UserAgentOriginal(r.UserAgent()),
+		requestIDKey.String(logging.RequestID(r.Context())),
+	}
+	if _, pattern := t.Routes.Handler(r); len(pattern) > 0 {
+		name = pattern
+		attrs = append(attrs, semconv.HTTPRoute(pattern))
+	}
+	ctx, span := t.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attrs...),
+	)
+	defer span.End()
+
+	recorder := logging.NewResponseRecorder(w)
+	t.Handler.ServeHTTP(recorder, r.WithContext(ctx))
+	span.SetAttributes(semconv.HTTPResponseStatusCode(recorder.Status()))
+	if recorder.Status() >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(recorder.Status()))
+	}
+}
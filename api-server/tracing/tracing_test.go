@@ -0,0 +1,89 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/KyleWS/blog-api/api-server/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestTracedExportsServerSpans(t *testing.T) {
+	const (
+		traceID      = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parentSpanID = "00f067aa0ba902b7"
+	)
+	exported := make(chan *collectortrace.ExportTraceServiceRequest, 10)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		export := &collectortrace.ExportTraceServiceRequest{}
+		if err != nil || r.URL.Path != "/v1/traces" || proto.Unmarshal(body, export) != nil {
+			t.Errorf("got an export to %s the collector could not read", r.URL.Path)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		exported <- export
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		resp, _ := proto.Marshal(&collectortrace.ExportTraceServiceResponse{})
+		w.Write(resp)
+	}))
+	defer collector.Close()
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", collector.URL)
+	shutdown, err := Setup(context.Background(), ExporterOTLP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the trace the handler passes on to services it calls
+	outgoing := http.Header{}
+	routes := http.NewServeMux()
+	routes.HandleFunc("GET /v1/posts/{id}", func(w http.ResponseWriter, r *http.Request) {
+		otel.GetTextMapPropagator().Inject(r.Context(), propagation.HeaderCarrier(outgoing))
+	})
+	traced := NewTraced(routes, routes)
+	r := httptest.NewRequest(http.MethodGet, "/v1/posts/1", nil)
+	r.Header.Set("traceparent", "00-"+traceID+"-"+parentSpanID+"-01")
+	r = r.WithContext(logging.WithRequestID(r.Context(), "request-1"))
+	traced.ServeHTTP(httptest.NewRecorder(), r)
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var span *tracepb.Span
+	for len(exported) > 0 && span == nil {
+		for _, resourceSpans := range (<-exported).ResourceSpans {
+			for _, scopeSpans := range resourceSpans.ScopeSpans {
+				for _, exportedSpan := range scopeSpans.Spans {
+					span = exportedSpan
+				}
+			}
+		}
+	}
+	if span == nil {
+		t.Fatal("got no span")
+	}
+	if span.Name != "GET /v1/posts/{id}" || span.Kind != tracepb.Span_SPAN_KIND_SERVER {
+		t.Errorf("got span %q of kind %v, want a server span named after the route", span.Name, span.Kind)
+	}
+	if hex.EncodeToString(span.TraceId) != traceID || hex.EncodeToString(span.ParentSpanId) != parentSpanID {
+		t.Errorf("got trace %x and parent %x, want the caller's", span.TraceId, span.ParentSpanId)
+	}
+	attrs := map[string]string{}
+	for _, attr := range span.Attributes {
+		attrs[attr.Key] = attr.Value.GetStringValue()
+	}
+	if attrs[string(requestIDKey)] != "request-1" {
+		t.Errorf("got attributes %v, want %s request-1", attrs, requestIDKey)
+	}
+	if want := "00-" + traceID + "-" + hex.EncodeToString(span.SpanId) + "-01"; outgoing.Get("traceparent") != want {
+		t.Errorf("got outgoing traceparent %q, want %q", outgoing.Get("traceparent"), want)
+	}
+}
@@ -0,0 +1,293 @@
+// Package webhooks tells subscribed URLs about changes to posts. Events
+// are queued in the store and delivered in the background, failed
+// deliveries are retried with exponential backoff.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/KyleWS/blog-api/api-server/logging"
+	"github.com/KyleWS/blog-api/api-server/models"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// HeaderEvent names the event a delivery is for
+	HeaderEvent = "X-Blog-Event"
+	// HeaderDelivery is the ID of the delivery, the same on every
+	// attempt so receivers can ignore repeats
+	HeaderDelivery = "X-Blog-Delivery"
+	// HeaderSignature is "sha256=" followed by the hex HMAC-SHA256 of
+	// the body, keyed with the webhook's secret
+	HeaderSignature = "X-Blog-Signature"
+)
+
+// Store keeps webhooks and their deliveries.
+type Store interface {
+	WebhooksFor(ctx context.Context, event string) ([]*models.Webhook, error)
+
+	GetWebhook(ctx context.Context, webhookID bson.ObjectId) (*models.Webhook, error)
+
+	InsertDelivery(ctx context.Context, delivery *models.WebhookDelivery) error
+
+	ClaimDelivery(ctx context.Context, now time.Time, lease time.Duration) (*models.WebhookDelivery, error)
+
+	RecordAttempt(ctx context.Context, deliveryID bson.ObjectId, attempt *models.DeliveryAttempt, status string, nextAttempt time.Time) error
+
+	RequeueDelivery(ctx context.Context, deliveryID bson.ObjectId) error
+}
+
+// Payload is the JSON body POSTed to webhooks.
+type Payload struct {
+	Event string           `json:"event"`
+	Time  time.Time        `json:"time"`
+	Actor string           `json:"actor"`
+	Post  *models.TextPost `json:"post"`
+}
+
+// Dispatcher queues events for the webhooks subscribed to them and
+// delivers them in the background.
+type Dispatcher struct {
+	Store  Store
+	Client *http.Client
+	// MaxAttempts is how many times a delivery is tried before it is
+	// marked failed
+	MaxAttempts int
+	// Backoff is the wait after the first failed attempt, doubling
+	// after each further one up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// PollInterval is how often the queue is checked for deliveries
+	// due for a retry
+	PollInterval time.Duration
+
+	wake    chan struct{}
+	stop    context.CancelFunc
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// NewDispatcher returns a dispatcher delivering the events queued in
+// store, giving each attempt timeout to complete.
+func NewDispatcher(store Store, timeout time.Duration, maxAttempts int) *Dispatcher {
+	return &Dispatcher{
+		Store:        store,
+		Client:       &http.Client{Timeout: timeout},
+		MaxAttempts:  maxAttempts,
+		Backoff:      30 * time.Second,
+		MaxBackoff:   time.Hour,
+		PollInterval: 10 * time.Second,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Sign returns the signature of body for secret, as sent in the
+// X-Blog-Signature header.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Publish queues event about post, done by actor, for every webhook
+// subscribed to it. Failing to queue is logged, the change to the post
+// has already been made.
+func (d *Dispatcher) Publish(ctx context.Context, event string, actor string, post *models.TextPost) {
+	logger := logging.FromContext(ctx).WithField("event", event)
+	webhooks, err := d.Store.WebhooksFor(ctx, event)
+	if err != nil {
+		logger.WithField("err", err).Error("error finding webhooks to notify")
+		return
+	}
+	if len(webhooks) == 0 {
+		return
+	}
+	body, err := json.Marshal(&Payload{
+		Event: event,
+		Time:  time.Now(),
+		Actor: actor,
+		Post:  post,
+	})
+	if err != nil {
+		logger.WithField("err", err).Error("error encoding webhook payload")
+		return
+	}
+	for _, webhook := range webhooks {
+		delivery := &models.WebhookDelivery{
+			ID:          bson.NewObjectId(),
+			WebhookID:   webhook.ID,
+			Event:       event,
+			Payload:     string(body),
+			Status:      models.DeliveryPending,
+			Created:     time.Now(),
+			NextAttempt: time.Now(),
+			Attempts:    make([]*models.DeliveryAttempt, 0),
+		}
+		if err := d.Store.InsertDelivery(ctx, delivery); err != nil {
+			logger.WithFields(logrus.Fields{
+				"webhook": webhook.ID.Hex(),
+				"err":     err,
+			}).Error("error queueing webhook delivery")
+		}
+	}
+	d.nudge()
+}
+
+// Redeliver queues the delivery with the given ID to be attempted
+// again now, whatever became of it before.
+func (d *Dispatcher) Redeliver(ctx context.Context, deliveryID bson.ObjectId) error {
+	if err := d.Store.RequeueDelivery(ctx, deliveryID); err != nil {
+		return err
+	}
+	d.nudge()
+	return nil
+}
+
+// Start delivers queued events in the background until Stop is called.
+func (d *Dispatcher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.stop = cancel
+	d.stopped = make(chan struct{})
+	go d.run(ctx)
+}
+
+// Stop waits for the delivery in progress, if any, giving up once ctx
+// is done. Deliveries still queued are sent after the next start.
+func (d *Dispatcher) Stop(ctx context.Context) error {
+	if d.stop == nil {
+		return nil
+	}
+	d.once.Do(d.stop)
+	select {
+	case <-d.stopped:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("error waiting for webhook delivery: %v", ctx.Err())
+	}
+}
+
+// nudge wakes the delivery loop without waiting for the next poll.
+func (d *Dispatcher) nudge() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Dispatcher) run(ctx context.Context) {
+	defer close(d.stopped)
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+	for {
+		d.deliverDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// deliverDue attempts every delivery that is due until none are left.
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		// held long enough for the attempt to finish before another
+		// instance may try it
+		delivery, err := d.Store.ClaimDelivery(ctx, time.Now(), 2*d.Client.Timeout)
+		if errors.Is(err, models.ErrNotFound) {
+			return
+		}
+		if err != nil {
+			logrus.WithField("err", err).Error("error claiming webhook delivery")
+			return
+		}
+		// finished even when stopping, the client timeout bounds it
+		d.attempt(context.Background(), delivery)
+	}
+}
+
+// attempt tries delivery once and records how it went.
+func (d *Dispatcher) attempt(ctx context.Context, delivery *models.WebhookDelivery) {
+	logger := logrus.WithFields(logrus.Fields{
+		"delivery": delivery.ID.Hex(),
+		"webhook":  delivery.WebhookID.Hex(),
+		"event":    delivery.Event,
+	})
+	start := time.Now()
+	attempt := &models.DeliveryAttempt{Time: start}
+	webhook, err := d.Store.GetWebhook(ctx, delivery.WebhookID)
+	if err == nil {
+		attempt.ResponseCode, err = d.post(ctx, webhook, delivery)
+	}
+	attempt.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+
+	status, next := models.DeliveryDelivered, time.Time{}
+	if err != nil {
+		attempt.Error = err.Error()
+		status, next = d.retry(len(delivery.Attempts)+1, errors.Is(err, models.ErrNotFound))
+		logger.WithFields(logrus.Fields{
+			"attempt": len(delivery.Attempts) + 1,
+			"status":  status,
+			"err":     err,
+		}).Warn("error delivering webhook")
+	}
+	if err := d.Store.RecordAttempt(ctx, delivery.ID, attempt, status, next); err != nil {
+		logger.WithField("err", err).Error("error recording webhook delivery attempt")
+	}
+}
+
+// retry returns the status and next attempt time of a delivery that
+// has failed attempts times. Deliveries to deleted webhooks are not
+// retried.
+func (d *Dispatcher) retry(attempts int, webhookGone bool) (string, time.Time) {
+	if webhookGone || attempts >= d.MaxAttempts {
+		return models.DeliveryFailed, time.Time{}
+	}
+	backoff := d.Backoff
+	for i := 1; i < attempts && backoff < d.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > d.MaxBackoff {
+		backoff = d.MaxBackoff
+	}
+	return models.DeliveryPending, time.Now().Add(backoff)
+}
+
+// post sends delivery to webhook, returning the response code. Any
+// response other than a 2xx is an error.
+func (d *Dispatcher) post(ctx context.Context, webhook *models.Webhook, delivery *models.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("error creating webhook request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "blog-api-webhooks")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, delivery.ID.Hex())
+	req.Header.Set(HeaderSignature, Sign(webhook.Secret, body))
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("error posting to webhook: %v", err)
+	}
+	defer resp.Body.Close()
+	// drained so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("error webhook responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,229 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/KyleWS/blog-api/api-server/models"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// memStore keeps webhooks and deliveries in memory, claiming
+// deliveries the way the Mongo store does.
+type memStore struct {
+	lock       sync.Mutex
+	webhooks   []*models.Webhook
+	deliveries []*models.WebhookDelivery
+}
+
+func (ms *memStore) WebhooksFor(ctx context.Context, event string) ([]*models.Webhook, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	return ms.webhooks, nil
+}
+
+func (ms *memStore) GetWebhook(ctx context.Context, webhookID bson.ObjectId) (*models.Webhook, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	for _, webhook := range ms.webhooks {
+		if webhook.ID == webhookID {
+			return webhook, nil
+		}
+	}
+	return nil, models.ErrNotFound
+}
+
+func (ms *memStore) InsertDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	ms.deliveries = append(ms.deliveries, delivery)
+	return nil
+}
+
+func (ms *memStore) ClaimDelivery(ctx context.Context, now time.Time, lease time.Duration) (*models.WebhookDelivery, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	due := make([]*models.WebhookDelivery, 0)
+	for _, delivery := range ms.deliveries {
+		if delivery.Status == models.DeliveryPending && !delivery.NextAttempt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	if len(due) == 0 {
+		return nil, models.ErrNotFound
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NextAttempt.Before(due[j].NextAttempt) })
+	due[0].NextAttempt = now.Add(lease)
+	claimed := *due[0]
+	return &claimed, nil
+}
+
+func (ms *memStore) RecordAttempt(ctx context.Context, deliveryID bson.ObjectId, attempt *models.DeliveryAttempt, status string, nextAttempt time.Time) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	delivery := ms.find(deliveryID)
+	if delivery == nil {
+		return models.ErrNotFound
+	}
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	delivery.Status = status
+	delivery.NextAttempt = nextAttempt
+	return nil
+}
+
+func (ms *memStore) RequeueDelivery(ctx context.Context, deliveryID bson.ObjectId) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	delivery := ms.find(deliveryID)
+	if delivery == nil {
+		return models.ErrNotFound
+	}
+	delivery.Status = models.DeliveryPending
+	delivery.NextAttempt = time.Now()
+	return nil
+}
+
+func (ms *memStore) find(deliveryID bson.ObjectId) *models.WebhookDelivery {
+	for _, delivery := range ms.deliveries {
+		if delivery.ID == deliveryID {
+			return delivery
+		}
+	}
+	return nil
+}
+
+// only returns a copy of the one delivery queued.
+func (ms *memStore) only(t *testing.T) models.WebhookDelivery {
+	t.Helper()
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	if len(ms.deliveries) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(ms.deliveries))
+	}
+	return *ms.deliveries[0]
+}
+
+// receivedRequest is a request made to the test receiver.
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// testDispatcher returns a dispatcher posting to a receiver answering
+// with status, and the store it delivers from with one webhook.
+func testDispatcher(t *testing.T, status int) (*Dispatcher, *memStore, chan *receivedRequest) {
+	t.Helper()
+	received := make(chan *receivedRequest, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- &receivedRequest{header: r.Header, body: body}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(receiver.Close)
+	store := &memStore{webhooks: []*models.Webhook{{
+		ID:     bson.NewObjectId(),
+		URL:    receiver.URL,
+		Secret: "shh",
+		Events: []string{models.WebhookPostCreated},
+	}}}
+	return NewDispatcher(store, time.Second, 3), store, received
+}
+
+func TestDispatcherSignsDeliveries(t *testing.T) {
+	dispatcher, store, received := testDispatcher(t, http.StatusNoContent)
+	dispatcher.Start()
+	defer dispatcher.Stop(context.Background())
+	dispatcher.Publish(context.Background(), models.WebhookPostCreated, "github:octocat", &models.TextPost{Title: "Hello"})
+
+	var r *receivedRequest
+	select {
+	case r = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not called")
+	}
+	mac := hmac.New(sha256.New, []byte("shh"))
+	mac.Write(r.body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); r.header.Get(HeaderSignature) != want {
+		t.Errorf("got signature %q, want %q", r.header.Get(HeaderSignature), want)
+	}
+	if r.header.Get(HeaderEvent) != models.WebhookPostCreated {
+		t.Errorf("got event %q", r.header.Get(HeaderEvent))
+	}
+	payload := &Payload{}
+	if err := json.Unmarshal(r.body, payload); err != nil || payload.Actor != "github:octocat" || payload.Post.Title != "Hello" {
+		t.Errorf("got payload %s and error %v", r.body, err)
+	}
+	delivery := store.only(t)
+	if r.header.Get(HeaderDelivery) != delivery.ID.Hex() {
+		t.Errorf("got delivery %q, want %q", r.header.Get(HeaderDelivery), delivery.ID.Hex())
+	}
+}
+
+func TestDispatcherBacksOff(t *testing.T) {
+	dispatcher, store, received := testDispatcher(t, http.StatusInternalServerError)
+	dispatcher.Backoff = time.Minute
+	dispatcher.MaxBackoff = 90 * time.Second
+	dispatcher.Publish(context.Background(), models.WebhookPostCreated, "github:octocat", &models.TextPost{})
+
+	for _, want := range []time.Duration{time.Minute, 90 * time.Second} {
+		before := time.Now()
+		dispatcher.deliverDue(context.Background())
+		<-received
+		delivery := store.only(t)
+		if delivery.Status != models.DeliveryPending {
+			t.Fatalf("got status %q, want %q", delivery.Status, models.DeliveryPending)
+		}
+		if wait := delivery.NextAttempt.Sub(before); wait < want || wait > want+time.Second {
+			t.Errorf("attempt %d: got next attempt in %v, want %v", len(delivery.Attempts), wait, want)
+		}
+		if len(delivery.Attempts) == 0 || delivery.Attempts[len(delivery.Attempts)-1].ResponseCode != http.StatusInternalServerError {
+			t.Errorf("got attempts %+v", delivery.Attempts)
+		}
+		// nothing is attempted before the backoff has passed
+		dispatcher.deliverDue(context.Background())
+		if len(received) > 0 {
+			t.Fatal("delivery attempted before its backoff passed")
+		}
+		store.RequeueDelivery(context.Background(), delivery.ID)
+	}
+
+	dispatcher.deliverDue(context.Background())
+	<-received
+	if delivery := store.only(t); delivery.Status != models.DeliveryFailed || len(delivery.Attempts) != 3 {
+		t.Errorf("got status %q after %d attempts, want %q after 3", delivery.Status, len(delivery.Attempts), models.DeliveryFailed)
+	}
+}
+
+func TestDispatcherReclaimsLease(t *testing.T) {
+	dispatcher, store, received := testDispatcher(t, http.StatusOK)
+	dispatcher.Client.Timeout = 50 * time.Millisecond
+	dispatcher.Publish(context.Background(), models.WebhookPostCreated, "github:octocat", &models.TextPost{})
+
+	// a worker claims the delivery and dies before recording anything
+	if _, err := store.ClaimDelivery(context.Background(), time.Now(), 2*dispatcher.Client.Timeout); err != nil {
+		t.Fatal(err)
+	}
+	dispatcher.deliverDue(context.Background())
+	if len(received) > 0 {
+		t.Fatal("delivery attempted while another worker held it")
+	}
+
+	time.Sleep(3 * dispatcher.Client.Timeout)
+	dispatcher.deliverDue(context.Background())
+	if len(received) != 1 {
+		t.Fatalf("got %d attempts once the lease passed, want 1", len(received))
+	}
+	if delivery := store.only(t); delivery.Status != models.DeliveryDelivered {
+		t.Errorf("got status %q, want %q", delivery.Status, models.DeliveryDelivered)
+	}
+}